@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/flightaware/baremaps-exporter/v2/pkg/tileutils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/twpayne/go-mbtiles"
+)
+
+const emptyTilesBatchSize = 1000
+
+// queryCoverage returns the tiles at coverageZoom that contain geometry from a single layer query
+func queryCoverage(ctx context.Context, pool *pgxpool.Pool, query string, coverageZoom int, bbox tileutils.BoundingBox) (tileutils.TileSet, error) {
+	rows, err := pool.Query(ctx, tileutils.CoverageQuery(query, coverageZoom, bbox))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cells := tileutils.TileSet{}
+	for rows.Next() {
+		var x, y int
+		if err := rows.Scan(&x, &y); err != nil {
+			return nil, err
+		}
+		cells.Add(tileutils.TileCoords{Z: coverageZoom, X: x, Y: y})
+	}
+	return cells, rows.Err()
+}
+
+// buildCoverage runs the coverage pre-pass. For each zoom at or above coverageZoom, it returns the set of
+// tiles at coverageZoom that contain geometry from any of the layer queries used at that zoom.
+// Queries shared between zooms are only run once.
+func buildCoverage(ctx context.Context, pool *pgxpool.Pool, queryMap tileutils.ZoomLayerInfo, zooms []int, coverageZoom int, bbox tileutils.BoundingBox) (map[int]tileutils.TileSet, error) {
+	queryCells := map[string]tileutils.TileSet{}
+	coverage := map[int]tileutils.TileSet{}
+	for _, z := range zooms {
+		if z < coverageZoom {
+			continue
+		}
+		zoomCells := tileutils.TileSet{}
+		for layerName, queries := range queryMap[z] {
+			for _, query := range queries {
+				cells, ok := queryCells[query]
+				if !ok {
+					var err error
+					cells, err = queryCoverage(ctx, pool, query, coverageZoom, bbox)
+					if err != nil {
+						return nil, fmt.Errorf("error checking coverage of layer %s at zoom %d: %w", layerName, z, err)
+					}
+					queryCells[query] = cells
+				}
+				for c := range cells {
+					zoomCells.Add(c)
+				}
+			}
+		}
+		coverage[z] = zoomCells
+	}
+	return coverage, nil
+}
+
+// writeEmptyTiles writes the same canned empty tile for every tile in the list, stopping early if ctx is cancelled
+func writeEmptyTiles(ctx context.Context, tiles []tileutils.TileCoords, writer tileutils.TileWriter, bulkWriter tileutils.TileBulkWriter, compress bool) error {
+	emptyTile := []byte{}
+	if compress {
+		compressed, err := tileutils.Gzip(emptyTile)
+		if err != nil {
+			return err
+		}
+		emptyTile = compressed
+	}
+	if bulkWriter == nil {
+		for _, c := range tiles {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := writer.Write(c.Z, c.X, c.Y, emptyTile); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	batch := make([]mbtiles.TileData, 0, emptyTilesBatchSize)
+	for i, c := range tiles {
+		batch = append(batch, mbtiles.TileData{
+			Z:    c.Z,
+			X:    c.X,
+			Y:    c.Y,
+			Data: emptyTile,
+		})
+		if len(batch) == emptyTilesBatchSize || i == len(tiles)-1 {
+			if err := bulkWriter.BulkWrite(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+	}
+	return nil
+}
@@ -2,236 +2,747 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/flightaware/baremaps-exporter/v2/pkg/tileutils"
 
 	"github.com/alexflint/go-arg"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/twpayne/go-mbtiles"
 	"golang.org/x/exp/slices"
 )
 
 const (
-	progressUpdateRate = time.Duration(15) * time.Second
-	mbTilesBatchSize   = 10
-)
+	progressTextFormat = "text"
+	progressJSONFormat = "json"
+	exitInterrupted    = 130 // exit code after SIGINT or SIGTERM, like a shell reports an interrupted command
 
-var (
-	workerProgress      = map[int]int{} // workerProgress checks how many tiles each worker has completed
-	workerProgressMutex sync.Mutex
+	// a worker that can't get a connection waits between these before taking its next batch
+	minConnectBackoff = time.Second
+	maxConnectBackoff = 30 * time.Second
 )
 
+// interruptContext returns a context that is cancelled on SIGINT or SIGTERM.
+// After the first signal, a second one exits immediately.
+func interruptContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		slog.Warn("interrupted, finishing the tiles in progress and closing the output (interrupt again to exit now)")
+	}()
+	return ctx
+}
+
+// LogArgs are the logging options of every command
+type LogArgs struct {
+	LogLevel  string `arg:"--log-level" default:"info" help:"lowest level of the messages to log: debug, info, warn or error"`
+	LogFormat string `arg:"--log-format" default:"text" help:"format of the log messages: text or json"`
+}
+
+// Commands are the subcommands of the exporter. The export arguments can also be given without
+// the export command, like before the other commands existed.
+type Commands struct {
+	Export      *Args            `arg:"subcommand:export" help:"export baremaps-compatible tilesets from a postgis server (the default command)"`
+	Merge       *MergeArgs       `arg:"subcommand:merge" help:"merge the mbtiles files of a sharded export (--shard) into one mbtiles file"`
+	Serve       *ServeArgs       `arg:"subcommand:serve" help:"serve tiles rendered on request from a postgis server, for developing the tilejson queries"`
+	ServeOutput *ServeOutputArgs `arg:"subcommand:serve-output" help:"serve the tiles of an exported mbtiles file or tile directory"`
+}
+
+func (Commands) Description() string {
+	return "export baremaps-compatible tilesets from a postgis server, and merge or serve them"
+}
+
+// parseCommands parses the command line, exiting on errors or --help.
+// Arguments that don't start with a command name are export arguments.
+func parseCommands(argv []string) Commands {
+	var cmds Commands
+	p, err := arg.NewParser(arg.Config{Program: "baremaps-exporter"}, &cmds)
+	if err != nil {
+		panic(err)
+	}
+	if len(argv) == 0 || !isCommand(argv[0]) {
+		argv = append([]string{"export"}, argv...)
+	}
+	err = p.Parse(argv)
+	switch {
+	case err == arg.ErrHelp:
+		p.WriteHelp(os.Stdout)
+		os.Exit(0)
+	case err != nil:
+		// show the usage of the command that failed
+		p.FailSubcommand(err.Error(), p.SubcommandNames()...)
+	}
+	return cmds
+}
+
+// isCommand reports whether the argument names a command, or asks for the list of commands
+func isCommand(a string) bool {
+	switch a {
+	case "export", "merge", "serve", "serve-output", "-h", "--help":
+		return true
+	}
+	return false
+}
+
+// setupLogging makes the logger configured by args the default logger
+func setupLogging(args LogArgs) {
+	logger, err := tileutils.NewLogger(os.Stderr, args.LogLevel, args.LogFormat)
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+}
+
 type Args struct {
 	TileJSON   string `arg:"positional,required" help:"input tilejson file"`
 	Output     string `arg:"-o,--output" help:"output file or directory"`
 	MbTiles    bool   `arg:"--mbtiles" help:"output mbtiles instead of files (automatically selected if output filename ends in '.mbtiles')"`
 	Dsn        string `arg:"-d,--dsn" help:"database connection string (dsn) for postgis"`
-	NumWorkers int    `arg:"-w,--workers" help:"number of workers to spawn"`
+	NumWorkers int    `arg:"-w,--workers" help:"number of workers to spawn, the most tiles rendered at once with --adaptive (defaults to the number of cpus)"`
 	Version    string `arg:"--tileversion" help:"version of the tileset (string) written to mbtiles metadata"`
 	Zoom       string `arg:"--zoom" help:"comma-delimited set specific zooms to export (eg: 2,4,6,8)"`
-	TilesFile  string `arg:"-f,--file" help:"a list of tiles to also generate, from a file where each line is a z/x/y tile coordinate"`
-}
+	TilesFile  string `arg:"-f,--file" help:"a list of tiles to also generate, from a file where each line is a z/x/y tile coordinate, a z/x1-x2/y1-y2 range or a quadkey"`
+	Expire     bool   `arg:"--expire" help:"treat --file as an expire list (eg: from osm2pgsql) and generate the ancestors of each tile at every export zoom below it, leaving out tiles at zooms that aren't exported"`
+	ExpireDown bool   `arg:"--expire-descendants" help:"with --expire, also generate the descendants of each tile at every export zoom above it"`
 
-func (Args) Description() string {
-	return "export baremaps-compatible tilesets from a postgis server"
+	CoverageZoom     int               `arg:"--coverage-zoom" default:"-1" help:"skip tiles without source data, using a pre-pass that finds which tiles at this coarse zoom contain any geometry (-1 disables the pre-pass)"`
+	WriteEmpty       bool              `arg:"--write-empty" help:"write a canned empty tile for tiles skipped by --coverage-zoom or --hierarchical instead of leaving them out"`
+	Order            string            `arg:"--order" default:"steps" help:"order tiles are queried and inserted in: steps, hilbert or morton"`
+	Shard            string            `arg:"--shard" help:"only export shard i of n (eg: 2/4), a deterministic subset of the tiles, for splitting an export across machines"`
+	Timings          string            `arg:"--timings" help:"sqlite file to record the render time of each tile in, which --slowest-first reads on the next run"`
+	SlowestFirst     bool              `arg:"--slowest-first" help:"start with the tiles that were slowest in the previous run recorded in --timings"`
+	SlowThreshold    time.Duration     `arg:"--slow-threshold" default:"1s" help:"with --slowest-first, tiles that took at least this long in the previous run are moved to the front"`
+	ZoomWorkers      string            `arg:"--zoom-workers" help:"maximum number of tiles rendered at once per zoom range, overriding zoom_concurrency in the tilejson (eg: 0-6:4,7-10:16)"`
+	TileStatsValues  int               `arg:"--tilestats-values" default:"100" help:"number of sample values kept for each attribute in the tilestats of the mbtiles metadata"`
+	Meta             map[string]string `arg:"--meta" help:"mbtiles metadata values to set, replacing the generated ones, as key=value pairs after a single --meta (eg: --meta type=overlay generator=baremaps-exporter)"`
+	BaseURL          string            `arg:"--base-url" default:"http://localhost:8080" help:"URL the output will be served from, for the tiles template of the tilejson written next to the output"`
+	PublicKeys       []string          `arg:"--public-keys" help:"keys of the input tilejson to also publish in the tilejson written next to the output, besides the ones of the TileJSON spec like fillzoom and legend"`
+	MetricsAddr      string            `arg:"--metrics-addr" help:"address to serve prometheus metrics on at /metrics while exporting (eg: :9090)"`
+	LayerTimings     bool              `arg:"--layer-timings" help:"render each layer of a tile with its own query, so the metrics have the query time of each layer, instead of one query for the whole tile"`
+	BatchSize        int               `arg:"--batch-size" default:"1000" help:"most tiles committed to the mbtiles in one transaction, also how many rendered tiles can wait to be written before workers block"`
+	BatchDuration    time.Duration     `arg:"--batch-duration" default:"2s" help:"longest a rendered tile waits before it is committed to the mbtiles"`
+	ThrottleQPS      *float64          `arg:"--throttle-qps" help:"most tile queries started per second (one per tile, or one per layer with --layer-timings), 0 for no limit"`
+	ThrottleDBTime   *float64          `arg:"--throttle-db-time" help:"most seconds of query time per second, summed over all workers, 0 for no limit"`
+	ThrottleSchedule string            `arg:"--throttle-schedule" help:"percent of the throttle limits to apply by local time of day, the first matching window wins (eg: 22:00-06:00=100,default=20)"`
+	ThrottleConfig   string            `arg:"--throttle-config" help:"json file with the throttle limits and schedule, overridden by the other throttle options"`
+	Adaptive         bool              `arg:"--adaptive" help:"adjust the number of tiles rendered at once between --min-workers and --workers, backing off when queries slow down or workers wait for connections"`
+	MinWorkers       int               `arg:"--min-workers" default:"1" help:"with --adaptive, the fewest tiles rendered at once, which is also where it starts"`
+	Hierarchical     bool              `arg:"--hierarchical" help:"process tiles one zoom at a time, parents first, and skip descendants of tiles that render empty when the deeper zooms use the same layer queries"`
+	Resume           bool              `arg:"--resume" help:"continue an interrupted mbtiles export with the same arguments: keep the tiles already in the output and skip the ones its journal records as written"`
+	ProgressEvery    time.Duration     `arg:"--progress-interval" default:"15s" help:"time between progress updates"`
+	ProgressFormat   string            `arg:"--progress-format" default:"text" help:"format of the progress updates: text (log lines) or json (json lines on stdout, with the progress of each zoom)"`
+	Report           string            `arg:"--report" help:"json file to write a report of the run to when it ends: tile counts, sizes and query times per zoom, the slowest and largest tiles, layer sizes, write throughput and time per phase"`
+	LogArgs
 }
 
 type WorkerParams struct {
-	Num             int                      // worker number
-	Wg              *sync.WaitGroup          // waitgroup to signal when completed
-	Args            Args                     // input args
-	TileList        []tileutils.TileCoords   // coords that this worker should process
-	QueryMap        tileutils.ZoomLayerInfo  // a map of the queries relevant at each zoom level
-	GzipCompression bool                     // true if gzip compression should be used
-	Writer          tileutils.TileWriter     // writer to use for output
-	BulkWriter      tileutils.TileBulkWriter // bulk writer if available
-	Pool            *pgxpool.Pool            // postgres connection pool
+	Num             int                           // worker number
+	Wg              *sync.WaitGroup               // waitgroup to signal when completed
+	Args            Args                          // input args
+	Queue           *tileutils.TileQueue          // shared queue of coords to process
+	QueryMap        tileutils.ZoomLayerInfo       // a map of the queries relevant at each zoom level
+	GzipCompression bool                          // true if gzip compression should be used
+	Writer          tileutils.TileWriter          // writer to use for output
+	Pool            *pgxpool.Pool                 // postgres connection pool
+	EmptyTiles      *tileutils.EmptyTiles         // records tiles that render empty, if not nil
+	Timings         *tileutils.TimingStore        // records the render time of each tile, if not nil
+	Limiter         *tileutils.ZoomLimiter        // limits the concurrent tiles at each zoom
+	Concurrency     *tileutils.ConcurrencyLimiter // limits the concurrent tiles overall, adjusted with --adaptive
+	Layers          *tileutils.LayerCollector     // collects the attributes of each layer, if not nil
+	Metrics         *exportMetrics                // counts the finished tiles
+	Report          *tileutils.ReportCollector    // collects the stats of the run report, if not nil
+	Progress        *tileutils.ProgressTracker    // tracks the finished tiles for the progress updates
+	Throttle        *tileutils.Throttle           // delays tile queries to limit the database load, if not nil
+	LayerTimings    bool                          // render each layer with its own query to time it
 }
 
-// newWriters creates a TileWriter and TileBulkWriter based on the input arguments
-func newWriters(args Args, tj *tileutils.TileJSON) (writer tileutils.TileWriter, bulkWriter tileutils.TileBulkWriter, close func(), err error) {
+// newWriters creates a TileWriter and TileBulkWriter based on the input arguments.
+// The tiles written through them are recorded in extent. On close, the mbtiles metadata is rewritten
+// from the extent and the fields and stats collected by layers (if not nil), the tiles in skipped (if not nil)
+// are recorded for the merge command, and a TileJSON document is written next to the output.
+// Closing a complete export also drops the mbtiles export journal.
+//
+// With --resume, the tiles already in the output are added to extent, layers and empty (if not nil),
+// and the tiles recorded in the journal are returned as done.
+// close is given whether the run finished without errors and the tiles it had to write, and returns whether
+// the output is complete. An incomplete mbtiles keeps its journal and saves its state for --resume.
+func newWriters(args Args, tj *tileutils.TileJSON, layers *tileutils.LayerCollector, extent *tileutils.TileExtent, empty *tileutils.EmptyTiles, skipped *tileutils.SkippedTiles) (writer tileutils.TileWriter, bulkWriter tileutils.TileBulkWriter, close func(complete bool, tiles []tileutils.TileCoords) bool, done *tileutils.DoneTiles, err error) {
 	var mbWriter *tileutils.MbTilesWriter
 	if args.Output == "" {
-		writer = &tileutils.DummyWriter{}
+		writer, _, err = (&tileutils.DummyWriter{}).New()
+		close = func(complete bool, _ []tileutils.TileCoords) bool { return complete }
 		return
 	}
+	var closeWriter func()
+	var writeMetadata func(tj *tileutils.TileJSON, layers *tileutils.LayerCollector) error
 	if args.MbTiles {
 		mbWriter = &tileutils.MbTilesWriter{
 			Filename: args.Output,
+			Journal:  true,
+			Resume:   args.Resume,
 		}
 		writer = mbWriter
 		bulkWriter = mbWriter
-		writer, close, err = mbWriter.New()
+		writer, closeWriter, err = mbWriter.New()
+		if err != nil {
+			return
+		}
+		if args.Resume {
+			if done, err = resumeOutput(mbWriter, extent, layers, empty); err != nil {
+				closeWriter()
+				return
+			}
+		}
+		writeMetadata = func(tj *tileutils.TileJSON, layers *tileutils.LayerCollector) error {
+			opts := tileutils.CreateMetadataOptions{
+				Filename:  args.TileJSON,
+				Version:   args.Version,
+				Format:    tileutils.MbTilesFormatPbf,
+				Overrides: args.Meta,
+			}
+			if layers != nil {
+				opts.Fields = layers.Fields()
+				opts.TileStats = layers.TileStats()
+			}
+			meta := tileutils.CreateMetadata(tj, opts)
+			if args.Shard != "" {
+				// record the shard so the merge command can check all shards are present
+				meta["shard"] = args.Shard
+			}
+			return mbWriter.BulkWriteMetadata(meta)
+		}
+		if err = writeMetadata(tj, nil); err != nil {
+			return
+		}
+	} else {
+		writer = &tileutils.FileWriter{
+			Path: args.Output,
+		}
+		writer, closeWriter, err = writer.New()
 		if err != nil {
 			return
 		}
-		meta := tileutils.CreateMetadata(tj, tileutils.CreateMetadataOptions{
-			Filename: args.TileJSON,
-			Version:  args.Version,
-			Format:   tileutils.MbTilesFormatPbf,
-		})
-		err = mbWriter.BulkWriteMetadata(meta)
-		return
 	}
-	writer = &tileutils.FileWriter{
-		Path: args.Output,
+	extentWriter := &tileutils.ExtentWriter{Writer: writer, BulkWriter: bulkWriter, Extent: extent}
+	writer = extentWriter
+	if bulkWriter != nil {
+		bulkWriter = extentWriter
+	}
+	close = func(complete bool, tiles []tileutils.TileCoords) bool {
+		if complete && mbWriter != nil {
+			complete = journalComplete(mbWriter, tiles, skipped)
+		}
+		// the extent, layer fields and stats are only known once all tiles are written
+		written := extent.Apply(tj)
+		if mbWriter != nil {
+			if err := writeMetadata(written, layers); err != nil {
+				slog.Error("error writing metadata", "error", err)
+			}
+			if skipped != nil && skipped.Len() > 0 {
+				if err := mbWriter.RecordSkipped(skipped.Subtrees()); err != nil {
+					slog.Error("error recording skipped tiles", "error", err)
+				}
+			}
+			if complete {
+				if err := mbWriter.DropJournal(); err != nil {
+					slog.Error("error dropping export journal", "error", err)
+				}
+			} else if err := mbWriter.SaveState(extent, layers); err != nil {
+				slog.Error("error saving export state, --resume will read the tiles back instead", "error", err)
+			}
+		}
+		if err := writeTileJSONDocument(args, written, layers); err != nil {
+			slog.Error("error writing tilejson", "error", err)
+		}
+		closeWriter()
+		return complete
 	}
-	writer, close, err = writer.New()
 	return
 }
 
-func connectWithRetries(pool *pgxpool.Pool, numRetries int) (*pgxpool.Conn, error) {
+// journalComplete reports whether the journal has every tile of the run that wasn't skipped
+func journalComplete(w *tileutils.MbTilesWriter, tiles []tileutils.TileCoords, skipped *tileutils.SkippedTiles) bool {
+	done, err := w.ReadJournal()
+	if err != nil {
+		slog.Error("error reading export journal", "error", err)
+		return false
+	}
+	if missing := tileutils.MissingTiles(tiles, done, skipped); missing > 0 {
+		slog.Warn("tiles missing from the output, keeping the export journal for --resume", "tiles", missing)
+		return false
+	}
+	return true
+}
+
+// resumeOutput reads the journal of an interrupted export, and the extent, layers and empty tiles
+// of the tiles it already wrote, since the metadata is rewritten from them on close.
+// The extent and layers come from the state saved with the journal, if it is up to date.
+func resumeOutput(w *tileutils.MbTilesWriter, extent *tileutils.TileExtent, layers *tileutils.LayerCollector, empty *tileutils.EmptyTiles) (*tileutils.DoneTiles, error) {
+	done, err := w.ReadJournal()
+	if err != nil {
+		return nil, err
+	}
+	ok, err := w.LoadState(extent, layers)
+	if err != nil {
+		slog.Error("error loading export state", "error", err)
+	}
+	if ok {
+		if empty == nil {
+			return done, nil
+		}
+		return done, w.ScanEmptyTiles(empty.Add)
+	}
+	slog.Info("no export state matching the journal, reading back the tiles already written")
+	err = w.ScanTiles(func(tc tileutils.TileCoords, data []byte) error {
+		tile, err := tileutils.Gunzip(data)
+		if err != nil {
+			slog.Error("error decompressing existing tile", tileutils.TileAttr(tc), "error", err)
+			extent.Add(tc)
+			return nil
+		}
+		if len(tile) == 0 {
+			extent.AddEmpty(tc)
+			if empty != nil {
+				empty.Add(tc)
+			}
+			return nil
+		}
+		extent.Add(tc)
+		if layers != nil {
+			if err := layers.Add(tile); err != nil {
+				slog.Error("error decoding existing tile for layer fields", tileutils.TileAttr(tc), "error", err)
+			}
+		}
+		return nil
+	})
+	return done, err
+}
+
+// writeTileJSONDocument writes the public TileJSON document of the export next to the output
+func writeTileJSONDocument(args Args, tj *tileutils.TileJSON, layers *tileutils.LayerCollector) error {
+	filename := tileutils.TileJSONDocumentPath(args.Output, !args.MbTiles)
+	if sameFile(filename, args.TileJSON) {
+		// never replace the input, it has the queries
+		return fmt.Errorf("not overwriting the input tilejson %s", args.TileJSON)
+	}
+	opts := tileutils.TileJSONDocumentOptions{
+		BaseURL:    args.BaseURL,
+		Version:    args.Version,
+		PublicKeys: args.PublicKeys,
+	}
+	if layers != nil {
+		opts.Fields = layers.Fields()
+	}
+	return tileutils.WriteTileJSONDocument(filename, tileutils.CreateTileJSONDocument(tj, opts))
+}
+
+// sameFile reports whether the two paths are the same file
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func connectWithRetries(ctx context.Context, pool *pgxpool.Pool, numRetries int) (*pgxpool.Conn, error) {
 	var lastErr error
 	for i := 0; i < numRetries; i++ {
-		conn, err := pool.Acquire(context.Background())
+		conn, err := pool.Acquire(ctx)
 		if err == nil {
 			return conn, nil
 		}
 		lastErr = err
-		time.Sleep(time.Duration(100) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(100) * time.Millisecond):
+		}
 	}
 	return nil, lastErr
 }
 
-func progressReporter(total int, numWorkers int) {
-	ticker := time.NewTicker(progressUpdateRate)
-	start := time.Now()
+// progressReporter reports the progress every interval, as log lines or as json lines on stdout,
+// along with the state of the throttle if not nil.
+// Calling stop reports the final progress and waits for it to be written.
+func progressReporter(tracker *tileutils.ProgressTracker, throttle *tileutils.Throttle, interval time.Duration, format string) (stop func()) {
+	report := func(p tileutils.Progress) {
+		if throttle != nil {
+			status := throttle.Status()
+			p.Throttle = &status
+		}
+		reportProgress(p, format)
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case t := <-ticker.C:
+				report(tracker.Snapshot(t, false))
+			case <-done:
+				report(tracker.Snapshot(time.Now(), true))
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// throttleConfig reads the throttle config file if any, and applies the throttle options over it
+func throttleConfig(args Args) (tileutils.ThrottleConfig, error) {
+	var c tileutils.ThrottleConfig
+	if args.ThrottleConfig != "" {
+		var err error
+		if c, err = tileutils.LoadThrottleConfig(args.ThrottleConfig); err != nil {
+			return c, err
+		}
+	}
+	// the options are pointers so that 0 can lift a limit of the config file
+	if args.ThrottleQPS != nil {
+		c.QPS = *args.ThrottleQPS
+	}
+	if args.ThrottleDBTime != nil {
+		c.DBTime = *args.ThrottleDBTime
+	}
+	if args.ThrottleSchedule != "" {
+		windows, percent, err := tileutils.ParseThrottleSchedule(args.ThrottleSchedule)
+		if err != nil {
+			return c, err
+		}
+		c.Schedule = windows
+		c.Percent = percent
+	}
+	if len(c.Schedule) > 0 || c.Percent != nil {
+		if !c.Enabled() {
+			return c, fmt.Errorf("a throttle schedule needs --throttle-qps or --throttle-db-time limits to scale")
+		}
+	}
+	return c, nil
+}
+
+// adaptConcurrency adjusts the concurrency limit from the latency observed by the workers until ctx is done
+func adaptConcurrency(ctx context.Context, limiter *tileutils.ConcurrencyLimiter, progress *tileutils.ProgressTracker, metrics *exportMetrics) {
+	ticker := time.NewTicker(tileutils.DefaultAdaptiveInterval)
+	defer ticker.Stop()
 	for {
-		t := <-ticker.C
-		counter := 0
-		workerProgressMutex.Lock()
-		for _, v := range workerProgress {
-			counter += v
-		}
-		workerProgressMutex.Unlock()
-		progress := float64(counter) / float64(total) * 100.0
-		elapsed := time.Duration(int(t.Sub(start).Seconds())) * time.Second
-		var remaining time.Duration
-		totalTime := time.Duration(int(elapsed.Seconds()/(progress/100.0))) * time.Second
-		remaining = totalTime - elapsed
-		fmt.Printf("progress: %.2f%% (%s elapsed, %s remaining)\n", progress, elapsed, remaining)
-		if counter == total {
-			break
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
+		limit := limiter.Adjust()
+		progress.SetWorkers(limit)
+		metrics.workers.Set(float64(limit))
+		slog.Debug("adjusted concurrency", "workers", limit)
 	}
 }
 
-func tileWorker(params WorkerParams) {
-	// open db connection
-	conn, err := connectWithRetries(params.Pool, 5)
-	if err != nil {
-		fmt.Printf("could not acquire connection! %v\n", err)
-		params.Wg.Done()
+func reportProgress(p tileutils.Progress, format string) {
+	if format == progressJSONFormat {
+		if err := json.NewEncoder(os.Stdout).Encode(p); err != nil {
+			slog.Error("error writing progress", "error", err)
+		}
 		return
 	}
-	fmt.Printf("[%d] connected, compression=%t\n", params.Num, params.GzipCompression)
-	defer conn.Release()
-
-	tileCache := make([]mbtiles.TileData, mbTilesBatchSize)
-	tileCachePos := 0
-	count := 0
-	// extract all the tiles in this worker's list
-	for _, c := range params.TileList {
-		start := time.Now()
-		count += 1
-		workerProgressMutex.Lock()
-		workerProgress[params.Num] = count
-		workerProgressMutex.Unlock()
-		queryStr := "SELECT "
-		layerCount := 0
-		for layerName, sqlStmts := range params.QueryMap[c.Z] {
-			if layerCount > 0 {
-				queryStr += "||"
-			}
-			sql := "(WITH mvtgeom AS ("
-			for i, query := range sqlStmts {
-				template := "(SELECT ST_AsMVTGeom(t.geom, ST_TileEnvelope(%d, %d, %d)) AS geom, t.tags, t.id " +
-					"FROM (%s) AS t " +
-					"WHERE t.geom && ST_TileEnvelope(%d, %d, %d, margin => (64.0/4096)))"
-				_sql := fmt.Sprintf(template,
-					c.Z, c.X, c.Y,
-					strings.ReplaceAll(query, ";", ""),
-					c.Z, c.X, c.Y)
-				if i != 0 {
-					sql += " UNION "
+	remaining := "unknown"
+	if p.Remaining >= 0 {
+		remaining = (time.Duration(p.Remaining) * time.Second).String()
+	}
+	msg := "progress"
+	if p.Final {
+		msg = "final progress"
+	}
+	attrs := []any{
+		"percent", fmt.Sprintf("%.2f", p.Percent),
+		"done", p.Done,
+		"total", p.Total,
+		"rate", fmt.Sprintf("%.1f/s", p.Rate),
+		"elapsed", time.Duration(p.Elapsed) * time.Second,
+		"remaining", remaining,
+		"failed", p.Failed,
+		"pruned", p.Pruned,
+		"workers", p.Workers,
+	}
+	if p.Throttle != nil {
+		attrs = append(attrs,
+			"throttle", fmt.Sprintf("%g%%", p.Throttle.Percent),
+			"throttle_wait", time.Duration(p.Throttle.Waited)*time.Second)
+	}
+	slog.Info(msg, attrs...)
+}
+
+// tileWorker renders the tiles from the queue until it's empty or ctx is cancelled.
+// Once cancelled, in-flight queries are aborted, and the tiles already rendered are still written.
+func tileWorker(ctx context.Context, params WorkerParams) {
+	// signal we're done
+	defer params.Wg.Done()
+
+	render := renderOptions{
+		Throttle: params.Throttle,
+		PerLayer: params.LayerTimings,
+		OnQuery: func(c tileutils.TileCoords, layer, sql string, d time.Duration) {
+			params.Metrics.queryDone(c.Z, layer, d)
+			if d > time.Duration(5)*time.Second {
+				if layer != "" {
+					slog.Warn("slow layer", "worker", params.Num, tileutils.TileAttr(c), "layer", layer, "duration", d)
 				}
-				sql += _sql
+				slog.Debug("slow query", "worker", params.Num, tileutils.TileAttr(c), "layer", layer, "sql", sql)
 			}
-			queryStr += sql + fmt.Sprintf(") SELECT ST_AsMVT(mvtgeom.*, '%s') FROM mvtgeom )", layerName)
-			layerCount++
+		},
+	}
+	connected := false
+	var backoff time.Duration
+	// pull batches of tiles from the shared queue until it's empty
+	for ctx.Err() == nil {
+		batch := params.Queue.Next()
+		if batch == nil {
+			break
 		}
-		queryStr += " mvtTile;"
-		row := conn.QueryRow(context.Background(), queryStr)
-		var mvtTile []byte
-		err = row.Scan(&mvtTile)
-		if err != nil {
-			fmt.Printf("error during tile generation (%d,%d,%d): %v\n", c.Z, c.X, c.Y, err)
-			continue
+		z := batch[0].Z
+		var conn *pgxpool.Conn
+		release := func() {
+			conn.Release()
+			conn = nil
+			params.Concurrency.Release()
+			params.Limiter.Release(z)
 		}
-		if params.GzipCompression {
-			compressed, err := tileutils.Gzip(mvtTile)
-			if err != nil {
-				fmt.Printf("error compressing tile: %v\n", err)
+		for i, c := range batch {
+			if ctx.Err() != nil {
+				break
 			}
-			mvtTile = compressed
-		}
-		end := time.Now()
-		if end.Sub(start) > time.Duration(5)*time.Second {
-			fmt.Printf("[%d] slow tile: %d/%d/%d - %s\n", params.Num, c.Z, c.X, c.Y, end.Sub(start))
-			fmt.Println(queryStr)
-		}
-
-		if params.BulkWriter != nil {
-			tileCache[tileCachePos] = mbtiles.TileData{
-				Z:    c.Z,
-				X:    c.X,
-				Y:    c.Y,
-				Data: mvtTile,
+			// don't hold a connection while the throttle holds the tile back for long, shorter waits keep it
+			if conn != nil && params.Throttle != nil && params.Throttle.Delay() >= tileutils.ThrottleReleaseDelay {
+				release()
 			}
-			tileCachePos++
-			if tileCachePos == mbTilesBatchSize {
-				err := params.BulkWriter.BulkWrite(tileCache)
+			if conn == nil {
+				if params.Throttle != nil {
+					if err := params.Throttle.Ready(ctx); err != nil {
+						break
+					}
+				}
+				// wait for a free slot at this zoom before taking a connection from the pool
+				params.Limiter.Acquire(z)
+				params.Concurrency.Acquire()
+				waitStart := time.Now()
+				var err error
+				conn, err = connectWithRetries(ctx, params.Pool, 5)
 				if err != nil {
-					fmt.Printf("error writing tiles")
-					continue
+					params.Concurrency.Release()
+					params.Limiter.Release(z)
+					if ctx.Err() != nil {
+						break
+					}
+					// the tiles are already out of the queue, so count them as failed for --resume to retry,
+					// and keep the worker for when the database is back
+					slog.Error("could not acquire connection, failing the batch", "worker", params.Num, "tiles", len(batch)-i, "error", err)
+					for _, c := range batch[i:] {
+						params.Metrics.tileFailed(c.Z)
+						params.Report.TileFailed(c)
+						params.Progress.Failed(c.Z, 0)
+					}
+					backoff = min(max(2*backoff, minConnectBackoff), maxConnectBackoff)
+					if params.Queue.Len() > 0 {
+						select {
+						case <-ctx.Done():
+						case <-time.After(backoff):
+						}
+					}
+					break
+				}
+				backoff = 0
+				params.Concurrency.ObserveWait(time.Since(waitStart))
+				if !connected {
+					slog.Debug("connected", "worker", params.Num, "compression", params.GzipCompression)
+					connected = true
 				}
-				tileCachePos = 0
 			}
-
-		} else {
-			err := params.Writer.Write(c.Z, c.X, c.Y, mvtTile)
+			start := time.Now()
+			mvtTile, queryTime, err := renderTile(ctx, conn, c, params.QueryMap[c.Z], render)
+			renderTime := time.Since(start)
+			if err != nil && ctx.Err() != nil {
+				slog.Debug("tile interrupted", "worker", params.Num, tileutils.TileAttr(c))
+				break
+			}
 			if err != nil {
-				fmt.Printf("error writing tile (%d, %d, %d): %v\n", c.Z, c.X, c.Y, err)
+				params.Metrics.tileFailed(c.Z)
+				params.Report.TileFailed(c)
+				params.Progress.Failed(c.Z, renderTime)
+				slog.Error("error during tile generation", "worker", params.Num, tileutils.TileAttr(c), "error", err)
 				continue
 			}
+			params.Metrics.tileCompleted(c.Z, len(mvtTile))
+			params.Progress.Rendered(c.Z, renderTime)
+			params.Concurrency.ObserveLatency(c.Z, queryTime)
+			params.Report.TileRendered(c, queryTime, len(mvtTile))
+			if params.Report != nil {
+				if sizes, err := tileutils.LayerSizes(mvtTile); err != nil {
+					slog.Error("error reading tile layers", tileutils.TileAttr(c), "error", err)
+				} else {
+					for layer, size := range sizes {
+						params.Report.LayerRendered(layer, size)
+					}
+				}
+			}
+			if params.Timings != nil {
+				if err := params.Timings.Record(c, queryTime); err != nil {
+					slog.Error("error recording tile timing", tileutils.TileAttr(c), "error", err)
+				}
+			}
+			if params.EmptyTiles != nil && len(mvtTile) == 0 {
+				params.EmptyTiles.Add(c)
+			}
+			if params.Layers != nil {
+				if err := params.Layers.Add(mvtTile); err != nil {
+					slog.Error("error decoding tile for layer fields", tileutils.TileAttr(c), "error", err)
+				}
+			}
+			if params.GzipCompression {
+				compressed, err := tileutils.Gzip(mvtTile)
+				if err != nil {
+					slog.Error("error compressing tile", tileutils.TileAttr(c), "error", err)
+				}
+				mvtTile = compressed
+			}
+			end := time.Now()
+			if end.Sub(start) > time.Duration(5)*time.Second {
+				slog.Warn("slow tile", "worker", params.Num, tileutils.TileAttr(c), "duration", end.Sub(start))
+			}
+			if err := params.Writer.Write(c.Z, c.X, c.Y, mvtTile); err != nil {
+				slog.Error("error writing tile", "worker", params.Num, tileutils.TileAttr(c), "error", err)
+			}
+		}
+		if conn != nil {
+			release()
 		}
 	}
+}
 
-	if tileCachePos > 0 && params.BulkWriter != nil {
-		err := params.BulkWriter.BulkWrite(tileCache[:tileCachePos])
-		if err != nil {
-			fmt.Printf("error writing tiles")
+// parseZooms returns the sorted zooms to export, either from a comma-delimited list or the TileJSON's zoom range
+func parseZooms(zoom string, tj *tileutils.TileJSON) ([]int, error) {
+	var zooms []int
+	// if comma-delimited list of zooms, use those
+	if zoom != "" {
+		strZooms := strings.Split(zoom, ",")
+		zooms = make([]int, 0, len(strZooms))
+		for _, z := range strZooms {
+			intZoom, err := strconv.Atoi(z)
+			if err != nil {
+				return nil, err
+			}
+			zooms = append(zooms, intZoom)
+		}
+	} else {
+		zooms = make([]int, 0, tj.MaxZoom-tj.MinZoom+1)
+		for z := tj.MinZoom; z <= tj.MaxZoom; z++ {
+			zooms = append(zooms, z)
 		}
 	}
-	// signal we're done
-	params.Wg.Done()
+	if len(zooms) == 0 {
+		return nil, fmt.Errorf("no zooms to export")
+	}
+	slices.Sort(zooms)
+	return zooms, nil
+}
 
+// runWorkers processes the tiles with a pool of workers pulling from a shared queue and waits until they are done.
+// The first slow tiles are handed out one at a time.
+func runWorkers(ctx context.Context, tiles []tileutils.TileCoords, slow int, params WorkerParams) {
+	numWorkers := params.Args.NumWorkers
+	if numWorkers > len(tiles) {
+		numWorkers = len(tiles)
+	}
+	var wg sync.WaitGroup
+	// workers pull the tiles in order, so they are hitting similar geospatial entries and zoom at the same time
+	queue := tileutils.NewTileQueue(tiles, slow)
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		workerParams := params
+		workerParams.Num = i
+		workerParams.Wg = &wg
+		workerParams.Queue = queue
+		go tileWorker(ctx, workerParams)
+	}
+	wg.Wait()
 }
 
 func main() {
-	args := Args{
-		NumWorkers: runtime.NumCPU(),
+	cmds := parseCommands(os.Args[1:])
+	switch {
+	case cmds.Merge != nil:
+		mergeMain(*cmds.Merge)
+	case cmds.Serve != nil:
+		serveMain(*cmds.Serve)
+	case cmds.ServeOutput != nil:
+		serveOutputMain(*cmds.ServeOutput)
+	default:
+		exportMain(*cmds.Export)
+	}
+}
+
+func exportMain(args Args) {
+	setupLogging(args.LogArgs)
+	if args.NumWorkers == 0 {
+		args.NumWorkers = runtime.NumCPU()
+	}
+	ctx := interruptContext()
+	var report *tileutils.ReportCollector
+	if args.Report != "" {
+		report = tileutils.NewReportCollector(tileutils.DefaultReportTopTiles)
 	}
-	arg.MustParse(&args)
 	if strings.HasSuffix(args.Output, ".mbtiles") {
 		args.MbTiles = true
 	}
+	if args.Resume && (!args.MbTiles || args.Output == "") {
+		panic(fmt.Errorf("--resume needs an mbtiles output"))
+	}
+	if args.ProgressFormat != progressTextFormat && args.ProgressFormat != progressJSONFormat {
+		panic(fmt.Errorf("unknown progress format %q, expected text or json", args.ProgressFormat))
+	}
+	if args.Adaptive && (args.MinWorkers < 1 || args.MinWorkers > args.NumWorkers) {
+		panic(fmt.Errorf("--min-workers must be between 1 and --workers"))
+	}
+	if args.ProgressEvery <= 0 {
+		panic(fmt.Errorf("--progress-interval must be positive"))
+	}
+	throttle, err := throttleConfig(args)
+	if err != nil {
+		panic(err)
+	}
+
+	order, err := tileutils.ParseTileOrder(args.Order)
+	if err != nil {
+		panic(err)
+	}
+	var shard tileutils.Shard
+	if args.Shard != "" {
+		if shard, err = tileutils.ParseShard(args.Shard); err != nil {
+			panic(err)
+		}
+		// normalize the shard written to the metadata
+		args.Shard = shard.String()
+	}
 
 	// open postgres pool
 	config, err := pgxpool.ParseConfig(args.Dsn)
@@ -245,79 +756,236 @@ func main() {
 		panic(err)
 	}
 
-	config.MinConns = int32(runtime.NumCPU())
-	config.MaxConns = int32(2 * runtime.NumCPU())
-	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	zooms, err := parseZooms(args.Zoom, tileJSON)
 	if err != nil {
 		panic(err)
 	}
-
-	var wg sync.WaitGroup
-
-	var zooms []int
-	// if comma-delimited list of zooms, use those
-	if args.Zoom != "" {
-		strZooms := strings.Split(args.Zoom, ",")
-		zooms = make([]int, 0, len(strZooms))
-		for _, z := range strZooms {
-			intZoom, err := strconv.Atoi(z)
-			if err != nil {
-				panic(err)
-			}
-			zooms = append(zooms, intZoom)
-		}
-	} else {
-		zooms = make([]int, 0, tileJSON.MaxZoom-tileJSON.MinZoom+1)
-		for z := tileJSON.MinZoom; z <= tileJSON.MaxZoom; z++ {
-			zooms = append(zooms, z)
+	zoomLimits := tileJSON.ZoomConcurrency
+	if args.ZoomWorkers != "" {
+		if zoomLimits, err = tileutils.ParseZoomConcurrency(args.ZoomWorkers); err != nil {
+			panic(err)
 		}
 	}
-	slices.Sort(zooms)
+
+	// size the pool for the most tiles that can be rendered at once
+	config.MaxConns = int32(tileutils.MaxConcurrency(zoomLimits, zooms, args.NumWorkers))
+	config.MinConns = int32(runtime.NumCPU())
+	if config.MinConns > config.MaxConns {
+		config.MinConns = config.MaxConns
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		panic(err)
+	}
+	metrics := newExportMetrics()
+	metrics.registerPool(pool)
+	if args.MetricsAddr != "" {
+		metrics.serve(args.MetricsAddr)
+	}
+
 	// reset min/max zoom to match requested output
 	tileJSON.MinZoom = zooms[0]
 	tileJSON.MaxZoom = zooms[len(zooms)-1]
 
+	report.Phase("list")
 	tiles := tileutils.ListTiles(zooms, tileJSON)
+	if args.Shard != "" {
+		tiles = tileutils.ShardTiles(tiles, shard)
+		slog.Info("exporting shard", "shard", shard.String())
+	}
+	var skippedTiles []tileutils.TileCoords
+	var skipped *tileutils.SkippedTiles
+	if args.MbTiles && !args.WriteEmpty {
+		// record the tiles left out on purpose, so merging shards doesn't report them as missing
+		skipped = tileutils.NewSkippedTiles()
+	}
+	if args.CoverageZoom >= 0 {
+		report.Phase("coverage")
+		coverage, err := buildCoverage(ctx, pool, tileMap, zooms, args.CoverageZoom, tileJSON.BoundingBox())
+		if err != nil && ctx.Err() != nil {
+			// nothing has been written yet
+			os.Exit(exitInterrupted)
+		}
+		if err != nil {
+			panic(err)
+		}
+		tiles, skippedTiles = tileutils.FilterCoverage(tiles, coverage, args.CoverageZoom)
+		if skipped != nil {
+			skipped.Add(tileutils.CoverageSubtrees(skippedTiles, args.CoverageZoom))
+		}
+		slog.Info("skipping tiles without source data", "tiles", len(skippedTiles))
+	}
 	if args.TilesFile != "" {
+		report.Phase("list")
 		extraTiles, err := tileutils.TilesFromFile(args.TilesFile)
 		if err != nil {
 			panic(err)
 		}
-		fmt.Printf("read tile coordinates from file: %d\n", len(extraTiles))
-		tiles = append(tiles, extraTiles...)
+		slog.Info("read tile coordinates from file", "tiles", len(extraTiles))
+		if args.Expire {
+			extraTiles = tileutils.ExpandTiles(extraTiles, zooms, args.ExpireDown)
+			slog.Info("expanded expired tiles", "tiles", len(extraTiles))
+		}
+		if args.Shard != "" {
+			extraTiles = tileutils.ShardTiles(extraTiles, shard)
+		}
+		tiles = tileutils.AppendUnique(tiles, extraTiles)
+	}
+	tileutils.SortTiles(tiles, order)
+	var timings *tileutils.TimingStore
+	var costs map[tileutils.TileCoords]time.Duration
+	if args.Timings != "" {
+		timings, err = tileutils.OpenTimingStore(args.Timings)
+		if err != nil {
+			panic(err)
+		}
+		if args.SlowestFirst {
+			costs, err = timings.Load(args.SlowThreshold)
+			if err != nil {
+				panic(err)
+			}
+			slog.Info("slow tiles from the previous run to start with", "tiles", len(costs))
+		}
 	}
-	tileLen := len(tiles)
-	fmt.Printf("number of tiles: %d\n", tileLen)
 
-	writer, bulkWriter, close, err := newWriters(args, tileJSON)
+	var layers *tileutils.LayerCollector
+	if args.Output != "" {
+		layers = tileutils.NewLayerCollector(args.TileStatsValues)
+	}
+	var emptyTiles *tileutils.EmptyTiles
+	if args.Hierarchical {
+		emptyTiles = tileutils.NewEmptyTiles()
+	}
+	extent := tileutils.NewTileExtent()
+	report.Phase("open")
+	writer, bulkWriter, close, done, err := newWriters(args, tileJSON, layers, extent, emptyTiles, skipped)
 	if err != nil {
 		panic(err)
 	}
-
-	numWorkers := args.NumWorkers
-	if numWorkers > tileLen {
-		numWorkers = tileLen
+	if done != nil {
+		tiles = tileutils.SkipDone(tiles, done)
+		skippedTiles = tileutils.SkipDone(skippedTiles, done)
+		report.Resumed(done.Len())
+		slog.Info("resuming, skipping tiles already written", "tiles", done.Len())
+	}
+	tileLen := len(tiles)
+	slog.Info("number of tiles", "tiles", tileLen)
+	timed := &timedWriter{writer: writer, bulkWriter: bulkWriter, metrics: metrics, report: report}
+	writer = timed
+	var batchWriter *tileutils.BatchWriter
+	if bulkWriter != nil {
+		// workers queue their tiles for a single goroutine that commits them in large transactions,
+		// so they never wait on sqlite's write lock
+		batchWriter = tileutils.NewBatchWriter(timed, tileutils.BatchWriterOptions{
+			Size:     args.BatchSize,
+			Duration: args.BatchDuration,
+			Order:    order,
+		})
+		writer = batchWriter
+		bulkWriter = batchWriter
+	}
+	if args.WriteEmpty && len(skippedTiles) > 0 {
+		if err := writeEmptyTiles(ctx, skippedTiles, writer, bulkWriter, args.MbTiles); err != nil {
+			panic(err)
+		}
 	}
-	// round robin the tiles so workers are hitting similar geospatial entries and zoom at the same time
-	rrTiles := tileutils.RoundRobinTiles(tiles, numWorkers)
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		workerTiles := rrTiles[i]
-		params := WorkerParams{
-			Num:             i,
-			Wg:              &wg,
-			Args:            args,
-			Pool:            pool,
-			QueryMap:        tileMap,
-			Writer:          writer,
-			BulkWriter:      bulkWriter,
-			TileList:        workerTiles,
-			GzipCompression: args.MbTiles,
-		}
-		go tileWorker(params)
-	}
-	go progressReporter(tileLen, numWorkers)
 
-	wg.Wait()
-	close()
+	params := WorkerParams{
+		Args:            args,
+		Pool:            pool,
+		QueryMap:        tileMap,
+		Writer:          writer,
+		GzipCompression: args.MbTiles,
+		Timings:         timings,
+		Limiter:         tileutils.NewZoomLimiter(zoomLimits),
+		Concurrency:     tileutils.NewConcurrencyLimiter(args.NumWorkers, args.NumWorkers),
+		Layers:          layers,
+		Metrics:         metrics,
+		Report:          report,
+		LayerTimings:    args.LayerTimings,
+		Progress:        tileutils.NewProgressTracker(tiles, tileutils.DefaultProgressWindow),
+	}
+	if args.Adaptive {
+		params.Concurrency = tileutils.NewConcurrencyLimiter(args.MinWorkers, args.NumWorkers)
+	}
+	if throttle.Enabled() {
+		if params.Throttle, err = tileutils.NewThrottle(throttle); err != nil {
+			panic(err)
+		}
+	}
+	params.Progress.SetWorkers(params.Concurrency.Limit())
+	metrics.workers.Set(float64(params.Concurrency.Limit()))
+	report.Phase("render")
+	stopProgress := progressReporter(params.Progress, params.Throttle, args.ProgressEvery, args.ProgressFormat)
+	renderCtx, stopRender := context.WithCancel(ctx)
+	if args.Adaptive {
+		go adaptConcurrency(renderCtx, params.Concurrency, params.Progress, metrics)
+	}
+	if args.Hierarchical {
+		// process each zoom completely before its children so empty parents are known
+		params.EmptyTiles = emptyTiles
+		for _, zoomTiles := range tileutils.GroupByZoom(tiles) {
+			if ctx.Err() != nil {
+				break
+			}
+			kept, pruned := params.EmptyTiles.Prune(zoomTiles, tileJSON)
+			if len(pruned) > 0 {
+				slog.Info("pruned tiles with empty parents", "zoom", zoomTiles[0].Z, "tiles", len(pruned))
+				metrics.tilesPrunedAt(zoomTiles[0].Z, len(pruned))
+				report.TilesPruned(zoomTiles[0].Z, len(pruned))
+				params.Progress.Pruned(zoomTiles[0].Z, len(pruned))
+				if skipped != nil {
+					skipped.Add(params.EmptyTiles.PrunedSubtrees(pruned))
+				}
+				if args.WriteEmpty {
+					if err := writeEmptyTiles(ctx, pruned, writer, bulkWriter, args.MbTiles); err != nil {
+						panic(err)
+					}
+				}
+			}
+			ordered, slow := tileutils.SlowestFirst(kept, costs)
+			runWorkers(ctx, ordered, slow, params)
+		}
+	} else {
+		ordered, slow := tileutils.SlowestFirst(tiles, costs)
+		runWorkers(ctx, ordered, slow, params)
+	}
+	stopRender()
+	// writes aren't tied to ctx, so the tiles rendered before an interrupt are still committed
+	if batchWriter != nil {
+		if err := batchWriter.Close(); err != nil {
+			slog.Error("some tiles could not be written", "error", err)
+		}
+	}
+	stopProgress()
+	report.Phase("close")
+	// keep the journal if any tile is missing, so --resume can retry it
+	expected := tiles
+	if args.WriteEmpty {
+		expected = append(expected[:len(expected):len(expected)], skippedTiles...)
+	}
+	complete := close(ctx.Err() == nil && metrics.Failures() == 0, expected)
+	counts := extent.Counts()
+	writtenZooms := make([]int, 0, len(counts))
+	for z := range counts {
+		writtenZooms = append(writtenZooms, z)
+	}
+	sort.Ints(writtenZooms)
+	for _, z := range writtenZooms {
+		slog.Info("tiles written", "zoom", z, "tiles", counts[z])
+	}
+	if timings != nil {
+		if err := timings.Close(); err != nil {
+			slog.Error("error saving tile timings", "error", err)
+		}
+	}
+	if report != nil {
+		if err := tileutils.WriteRunReport(args.Report, report.Report(complete)); err != nil {
+			slog.Error("error writing run report", "error", err)
+		}
+	}
+	if ctx.Err() != nil {
+		slog.Warn("export interrupted, the output only has the tiles rendered before the interrupt")
+		os.Exit(exitInterrupted)
+	}
 }
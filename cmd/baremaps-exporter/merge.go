@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/flightaware/baremaps-exporter/v2/pkg/tileutils"
+)
+
+type MergeArgs struct {
+	Inputs     []string `arg:"positional,required" help:"mbtiles files of each shard"`
+	Output     string   `arg:"-o,--output,required" help:"output mbtiles file"`
+	TileJSON   string   `arg:"--tilejson" help:"input tilejson file of the export, to also check for missing tiles"`
+	Zoom       string   `arg:"--zoom" help:"comma-delimited set of zooms that were exported (eg: 2,4,6,8), used with --tilejson"`
+	Unfinished bool     `arg:"--unfinished" help:"also merge shards whose export was interrupted or had failed tiles, which still have an export journal"`
+
+	TileStatsValues int `arg:"--tilestats-values" default:"100" help:"number of sample values kept for each attribute in the merged tilestats, like the --tilestats-values of the export"`
+	LogArgs
+}
+
+// formatTiles prints a list of tiles as z/x/y coordinates
+func formatTiles(tiles []tileutils.TileCoords) string {
+	out := make([]string, len(tiles))
+	for i, tc := range tiles {
+		out[i] = fmt.Sprintf("%d/%d/%d", tc.Z, tc.X, tc.Y)
+	}
+	return strings.Join(out, ", ")
+}
+
+func mergeMain(args MergeArgs) {
+	setupLogging(args.LogArgs)
+
+	var expected []tileutils.TileCoords
+	if args.TileJSON != "" {
+		tileJSON, _, err := tileutils.ParseTileJSON(args.TileJSON)
+		if err != nil {
+			panic(err)
+		}
+		zooms, err := parseZooms(args.Zoom, tileJSON)
+		if err != nil {
+			panic(err)
+		}
+		expected = tileutils.ListTiles(zooms, tileJSON)
+	}
+
+	result, err := tileutils.MergeMbTiles(args.Output, args.Inputs, tileutils.MergeOptions{
+		Expected:        expected,
+		TileStatsValues: args.TileStatsValues,
+		Unfinished:      args.Unfinished,
+	})
+	if err != nil {
+		slog.Error("error merging shards", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("merged shards", "tiles", result.Tiles, "shards", len(args.Inputs), "output", args.Output)
+	ok := true
+	if result.Duplicates > 0 {
+		slog.Error("duplicate tiles", "tiles", result.Duplicates, "examples", formatTiles(result.DuplicateTiles))
+		ok = false
+	}
+	if result.Missing > 0 {
+		slog.Error("missing tiles", "tiles", result.Missing, "examples", formatTiles(result.MissingTiles))
+		ok = false
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/flightaware/baremaps-exporter/v2/pkg/tileutils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/twpayne/go-mbtiles"
+)
+
+// exportMetrics counts the progress of the export. The failures decide whether the export is complete,
+// and everything is exported to prometheus when --metrics-addr is set.
+type exportMetrics struct {
+	registry *prometheus.Registry
+
+	failed    atomic.Int64
+	unwritten atomic.Int64
+
+	tilesCompleted *prometheus.CounterVec
+	tilesFailed    *prometheus.CounterVec
+	tilesEmpty     *prometheus.CounterVec
+	tilesPruned    *prometheus.CounterVec
+	queryDuration  *prometheus.HistogramVec
+	tileSize       *prometheus.HistogramVec
+	writeDuration  *prometheus.HistogramVec
+	writeErrors    *prometheus.CounterVec
+	workers        prometheus.Gauge
+}
+
+func newExportMetrics() *exportMetrics {
+	m := &exportMetrics{
+		registry: prometheus.NewRegistry(),
+		tilesCompleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "baremaps_exporter_tiles_completed_total",
+			Help: "Tiles rendered, by zoom.",
+		}, []string{"zoom"}),
+		tilesFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "baremaps_exporter_tiles_failed_total",
+			Help: "Tiles whose queries failed, by zoom.",
+		}, []string{"zoom"}),
+		tilesEmpty: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "baremaps_exporter_tiles_empty_total",
+			Help: "Tiles rendered without any features, by zoom.",
+		}, []string{"zoom"}),
+		tilesPruned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "baremaps_exporter_tiles_pruned_total",
+			Help: "Tiles skipped because their parent was empty, by zoom.",
+		}, []string{"zoom"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "baremaps_exporter_query_duration_seconds",
+			Help:    "Time of the tile queries in postgis, by zoom and layer. The layer is empty unless --layer-timings renders each layer with its own query.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"zoom", "layer"}),
+		tileSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "baremaps_exporter_tile_size_bytes",
+			Help:    "Size of the rendered tiles before compression, by zoom.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+		}, []string{"zoom"}),
+		writeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "baremaps_exporter_write_duration_seconds",
+			Help:    "Time to write to the output, by kind of write (single or bulk).",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"kind"}),
+		writeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "baremaps_exporter_write_errors_total",
+			Help: "Tiles that couldn't be written to the output, by kind of write (single or bulk).",
+		}, []string{"kind"}),
+		workers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "baremaps_exporter_workers",
+			Help: "Tiles that may be rendered at once, adjusted over time with --adaptive.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.tilesCompleted,
+		m.tilesFailed,
+		m.tilesEmpty,
+		m.tilesPruned,
+		m.queryDuration,
+		m.tileSize,
+		m.writeDuration,
+		m.writeErrors,
+		m.workers,
+	)
+	return m
+}
+
+// Failures returns the number of tiles that failed to render or to be written
+func (m *exportMetrics) Failures() int {
+	return int(m.failed.Load() + m.unwritten.Load())
+}
+
+func (m *exportMetrics) writeFailed(kind string, n int) {
+	m.unwritten.Add(int64(n))
+	m.writeErrors.WithLabelValues(kind).Add(float64(n))
+}
+
+func (m *exportMetrics) tileCompleted(z int, size int) {
+	zoom := strconv.Itoa(z)
+	m.tilesCompleted.WithLabelValues(zoom).Inc()
+	m.tileSize.WithLabelValues(zoom).Observe(float64(size))
+	if size == 0 {
+		m.tilesEmpty.WithLabelValues(zoom).Inc()
+	}
+}
+
+func (m *exportMetrics) tileFailed(z int) {
+	m.failed.Add(1)
+	m.tilesFailed.WithLabelValues(strconv.Itoa(z)).Inc()
+}
+
+func (m *exportMetrics) tilesPrunedAt(z int, n int) {
+	m.tilesPruned.WithLabelValues(strconv.Itoa(z)).Add(float64(n))
+}
+
+func (m *exportMetrics) queryDone(z int, layer string, d time.Duration) {
+	m.queryDuration.WithLabelValues(strconv.Itoa(z), layer).Observe(d.Seconds())
+}
+
+// registerPool exports the connection pool stats
+func (m *exportMetrics) registerPool(pool *pgxpool.Pool) {
+	m.registry.MustRegister(&poolCollector{pool: pool})
+}
+
+// serve serves the metrics on addr at /metrics, in the background
+func (m *exportMetrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("error serving metrics", "addr", addr, "error", err)
+		}
+	}()
+}
+
+var (
+	poolAcquireWaitDesc = prometheus.NewDesc("baremaps_exporter_pool_acquire_wait_seconds_total",
+		"Total time spent waiting for a connection from the pool.", nil, nil)
+	poolAcquiresDesc = prometheus.NewDesc("baremaps_exporter_pool_acquires_total",
+		"Connections acquired from the pool.", nil, nil)
+	poolEmptyAcquiresDesc = prometheus.NewDesc("baremaps_exporter_pool_empty_acquires_total",
+		"Acquires that had to wait for a connection because the pool was empty.", nil, nil)
+	poolActiveConnsDesc = prometheus.NewDesc("baremaps_exporter_pool_active_connections",
+		"Connections currently in use.", nil, nil)
+	poolIdleConnsDesc = prometheus.NewDesc("baremaps_exporter_pool_idle_connections",
+		"Idle connections in the pool.", nil, nil)
+	poolMaxConnsDesc = prometheus.NewDesc("baremaps_exporter_pool_max_connections",
+		"Maximum size of the pool.", nil, nil)
+)
+
+// poolCollector reads the pgxpool stats at each scrape
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquireWaitDesc
+	ch <- poolAcquiresDesc
+	ch <- poolEmptyAcquiresDesc
+	ch <- poolActiveConnsDesc
+	ch <- poolIdleConnsDesc
+	ch <- poolMaxConnsDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquireWaitDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquiresDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolActiveConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConnsDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+}
+
+// timedWriter records how long the writes to the output take
+type timedWriter struct {
+	writer     tileutils.TileWriter
+	bulkWriter tileutils.TileBulkWriter
+	metrics    *exportMetrics
+	report     *tileutils.ReportCollector
+}
+
+func (w *timedWriter) Write(z, x, y int, tileData []byte) error {
+	start := time.Now()
+	err := w.writer.Write(z, x, y, tileData)
+	elapsed := time.Since(start)
+	w.metrics.writeDuration.WithLabelValues("single").Observe(elapsed.Seconds())
+	if err != nil {
+		w.metrics.writeFailed("single", 1)
+	} else {
+		w.report.Written([]mbtiles.TileData{{Z: z, X: x, Y: y, Data: tileData}}, elapsed)
+	}
+	return err
+}
+
+func (w *timedWriter) BulkWrite(data []mbtiles.TileData) error {
+	start := time.Now()
+	err := w.bulkWriter.BulkWrite(data)
+	elapsed := time.Since(start)
+	w.metrics.writeDuration.WithLabelValues("bulk").Observe(elapsed.Seconds())
+	if err != nil {
+		w.metrics.writeFailed("bulk", len(data))
+	} else {
+		w.report.Written(data, elapsed)
+	}
+	return err
+}
+
+func (w *timedWriter) New() (tileutils.TileWriter, func(), error) {
+	return w, func() {}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/flightaware/baremaps-exporter/v2/pkg/tileutils"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// querier runs a query returning one row, a pool connection or the pool itself
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// renderOptions are the hooks around the queries of renderTile
+type renderOptions struct {
+	Throttle *tileutils.Throttle // delays each query, if not nil
+	PerLayer bool                // render each layer with its own query, so the time spent on each layer is known
+	// OnQuery is called after each query that succeeds, if not nil. layer is empty for the query rendering every layer.
+	OnQuery func(c tileutils.TileCoords, layer, sql string, d time.Duration)
+}
+
+// renderTile renders the tile with a single query, or one query per layer with PerLayer.
+// Either way the layers are concatenated in name order, which is a valid MVT tile. It also returns
+// the time spent in queries, which leaves out the time waiting for the throttle.
+func renderTile(ctx context.Context, q querier, c tileutils.TileCoords, layers map[string][]string, opts renderOptions) ([]byte, time.Duration, error) {
+	if !opts.PerLayer {
+		return renderQuery(ctx, q, c, "", tileutils.TileQuery(c, layers), opts)
+	}
+	names := make([]string, 0, len(layers))
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var mvtTile []byte
+	var queryTime time.Duration
+	for _, name := range names {
+		layerTile, elapsed, err := renderQuery(ctx, q, c, name, tileutils.LayerQuery(c, name, layers[name]), opts)
+		queryTime += elapsed
+		if err != nil {
+			return nil, queryTime, err
+		}
+		mvtTile = append(mvtTile, layerTile...)
+	}
+	return mvtTile, queryTime, nil
+}
+
+// renderQuery runs one query of renderTile, waiting for the throttle first
+func renderQuery(ctx context.Context, q querier, c tileutils.TileCoords, layer, queryStr string, opts renderOptions) ([]byte, time.Duration, error) {
+	if opts.Throttle != nil {
+		if err := opts.Throttle.Wait(ctx); err != nil {
+			return nil, 0, err
+		}
+	}
+	start := time.Now()
+	var tile []byte
+	err := q.QueryRow(ctx, queryStr).Scan(&tile)
+	elapsed := time.Since(start)
+	if opts.Throttle != nil {
+		opts.Throttle.Done(elapsed)
+	}
+	if err != nil {
+		return nil, elapsed, err
+	}
+	if opts.OnQuery != nil {
+		opts.OnQuery(c, layer, queryStr, elapsed)
+	}
+	return tile, elapsed, nil
+}
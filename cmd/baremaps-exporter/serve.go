@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/flightaware/baremaps-exporter/v2/pkg/tileutils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	mvtContentType  = "application/vnd.mapbox-vector-tile"
+	shutdownTimeout = 5 * time.Second
+)
+
+type ServeArgs struct {
+	TileJSON      string        `arg:"positional,required" help:"input tilejson file"`
+	Dsn           string        `arg:"-d,--dsn" help:"database connection string (dsn) for postgis"`
+	Addr          string        `arg:"--addr" default:":8080" help:"address to serve the tiles on"`
+	BaseURL       string        `arg:"--base-url" help:"URL the tiles are served from, for the tiles template of /tiles.json (defaults to the host of the request)"`
+	PublicKeys    []string      `arg:"--public-keys" help:"keys of the input tilejson to also publish in /tiles.json, besides the ones of the TileJSON spec like fillzoom and legend"`
+	MaxConns      int           `arg:"--max-conns" help:"most database connections, the most tiles rendered at once (defaults to the number of cpus)"`
+	CacheTiles    int           `arg:"--cache-tiles" help:"number of rendered tiles to keep in memory, 0 disables the cache"`
+	WatchInterval time.Duration `arg:"--watch-interval" default:"1s" help:"time between checks of the tilejson for changes, which reload it and empty the cache (0 disables)"`
+	LogArgs
+}
+
+// serveState is the tilejson being served, replaced when the file changes
+type serveState struct {
+	tileJSON *tileutils.TileJSON
+	queries  tileutils.ZoomLayerInfo
+	modTime  time.Time
+	size     int64
+}
+
+// tileServer renders the tiles of a tilejson on request
+type tileServer struct {
+	args  ServeArgs
+	pool  *pgxpool.Pool
+	cache *tileutils.TileCache // gzipped tiles, if not nil
+	state atomic.Pointer[serveState]
+}
+
+// load parses the tilejson file, and returns the state to serve
+func (s *tileServer) load() (*serveState, error) {
+	info, err := os.Stat(s.args.TileJSON)
+	if err != nil {
+		return nil, err
+	}
+	tj, queries, err := tileutils.ParseTileJSON(s.args.TileJSON)
+	if err != nil {
+		return nil, err
+	}
+	return &serveState{tileJSON: tj, queries: queries, modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// watch reloads the tilejson when the file changes, until ctx is done.
+// A file that doesn't parse is logged and the previous tilejson is kept.
+func (s *tileServer) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(s.args.TileJSON)
+		current := s.state.Load()
+		if err != nil || (info.ModTime().Equal(current.modTime) && info.Size() == current.size) {
+			continue
+		}
+		state, err := s.load()
+		if err != nil {
+			slog.Error("error reloading tilejson, still serving the previous one", "file", s.args.TileJSON, "error", err)
+			// don't retry until the file changes again
+			current = &serveState{tileJSON: current.tileJSON, queries: current.queries, modTime: info.ModTime(), size: info.Size()}
+			s.state.Store(current)
+			continue
+		}
+		s.state.Store(state)
+		if s.cache != nil {
+			s.cache.Clear()
+		}
+		slog.Info("reloaded tilejson", "file", s.args.TileJSON)
+	}
+}
+
+// requestBaseURL returns the URL the tiles are served from, the host of the request unless baseURL is set
+func requestBaseURL(r *http.Request, baseURL string) string {
+	if baseURL != "" {
+		return baseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (s *tileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.URL.Path == "/tiles.json" {
+		s.serveTileJSON(w, r)
+		return
+	}
+	s.serveTile(w, r)
+}
+
+func (s *tileServer) serveTileJSON(w http.ResponseWriter, r *http.Request) {
+	state := s.state.Load()
+	doc := tileutils.CreateTileJSONDocument(state.tileJSON, tileutils.TileJSONDocumentOptions{
+		BaseURL:    requestBaseURL(r, s.args.BaseURL),
+		PublicKeys: s.args.PublicKeys,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		slog.Debug("error writing tilejson", "error", err)
+	}
+}
+
+func (s *tileServer) serveTile(w http.ResponseWriter, r *http.Request) {
+	tc, err := tileutils.ParseTilePath(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	state := s.state.Load()
+	layers, ok := state.queries[tc.Z]
+	if !ok || tc.Z < state.tileJSON.MinZoom || tc.Z > state.tileJSON.MaxZoom {
+		http.NotFound(w, r)
+		return
+	}
+	if s.cache != nil {
+		if data, ok := s.cache.Get(tc); ok {
+			slog.Debug("tile", tileutils.TileAttr(tc), "cached", true)
+			writeTile(w, r, data, true)
+			return
+		}
+	}
+	mvtTile, queryTime, err := renderTile(r.Context(), s.pool, tc, layers, renderOptions{})
+	if err != nil {
+		if r.Context().Err() == nil {
+			slog.Error("error during tile generation", tileutils.TileAttr(tc), "error", err)
+			http.Error(w, "error rendering tile", http.StatusInternalServerError)
+		}
+		return
+	}
+	slog.Debug("tile", tileutils.TileAttr(tc), "bytes", len(mvtTile), "duration", queryTime)
+	if len(mvtTile) > 0 {
+		if mvtTile, err = tileutils.Gzip(mvtTile); err != nil {
+			slog.Error("error compressing tile", tileutils.TileAttr(tc), "error", err)
+			http.Error(w, "error compressing tile", http.StatusInternalServerError)
+			return
+		}
+	}
+	// only cache tiles rendered from the tilejson that is still current
+	if s.cache != nil && s.state.Load() == state {
+		s.cache.Add(tc, mvtTile)
+	}
+	writeTile(w, r, mvtTile, true)
+}
+
+// acceptsGzip reports whether the Accept-Encoding of the request allows a gzip response
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		return q > 0
+	}
+	return false
+}
+
+// writeTile writes a tile response, gzipped if the request allows it. Empty tiles are a 204 No Content.
+func writeTile(w http.ResponseWriter, r *http.Request, data []byte, gzipped bool) {
+	w.Header().Set("Vary", "Accept-Encoding")
+	if len(data) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", mvtContentType)
+	if gzipped {
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+		} else {
+			var err error
+			if data, err = tileutils.Gunzip(data); err != nil {
+				http.Error(w, "error decompressing tile", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	w.Write(data)
+}
+
+// listenAndServe serves handler on addr until ctx is done, then waits a little for the requests in progress
+func listenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	slog.Info("serving tiles", "addr", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func serveMain(args ServeArgs) {
+	setupLogging(args.LogArgs)
+	if args.MaxConns == 0 {
+		args.MaxConns = runtime.NumCPU()
+	}
+	if args.MaxConns < 1 {
+		panic(errors.New("--max-conns must be at least 1"))
+	}
+	ctx := interruptContext()
+
+	config, err := pgxpool.ParseConfig(args.Dsn)
+	if err != nil {
+		panic(err)
+	}
+	config.MaxConns = int32(args.MaxConns)
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		panic(err)
+	}
+	defer pool.Close()
+
+	s := &tileServer{args: args, pool: pool}
+	if args.CacheTiles > 0 {
+		s.cache = tileutils.NewTileCache(args.CacheTiles)
+	}
+	state, err := s.load()
+	if err != nil {
+		panic(err)
+	}
+	s.state.Store(state)
+	if args.WatchInterval > 0 {
+		go s.watch(ctx, args.WatchInterval)
+	}
+	if err := listenAndServe(ctx, args.Addr, s); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/flightaware/baremaps-exporter/v2/pkg/tileutils"
+)
+
+type ServeOutputArgs struct {
+	Output     string        `arg:"positional,required" help:"mbtiles file or tile directory written by an export"`
+	Addr       string        `arg:"--addr" default:":8080" help:"address to serve the tiles on"`
+	BaseURL    string        `arg:"--base-url" help:"URL the tiles are served from, for the tiles template of /tiles.json (defaults to the host of the request)"`
+	PublicKeys []string      `arg:"--public-keys" help:"metadata keys of a mbtiles file to also publish in /tiles.json, besides the ones of the TileJSON spec like fillzoom and legend"`
+	MaxAge     time.Duration `arg:"--max-age" default:"1h" help:"how long clients and caches may reuse a tile or the tilejson, for the Cache-Control header (0 makes them revalidate every time)"`
+	LogArgs
+}
+
+// outputServer serves the tiles of an export as they are stored
+type outputServer struct {
+	reader  tileutils.TileReader
+	doc     *tileutils.TileJSONDocument // the tiles template is replaced for each request, nil if there is none
+	modTime time.Time                   // modification time of the tilejson
+	baseURL string
+	maxAge  time.Duration
+}
+
+// newOutputServer opens the output and reads its TileJSON: generated from the metadata of a mbtiles file,
+// or the tiles.json written inside a directory
+func newOutputServer(args ServeOutputArgs) (*outputServer, error) {
+	info, err := os.Stat(args.Output)
+	if err != nil {
+		return nil, err
+	}
+	s := &outputServer{baseURL: args.BaseURL, maxAge: args.MaxAge}
+	if info.IsDir() {
+		s.reader = &tileutils.DirectoryReader{Path: args.Output}
+		filename := tileutils.TileJSONDocumentPath(args.Output, true)
+		if docInfo, err := os.Stat(filename); err == nil {
+			s.modTime = docInfo.ModTime()
+			if s.doc, err = tileutils.ReadTileJSONDocument(filename); err != nil {
+				return nil, err
+			}
+		} else {
+			slog.Warn("no tilejson in the output directory, /tiles.json won't be served", "file", filename)
+		}
+		return s, nil
+	}
+	reader, err := tileutils.OpenMbTilesReader(args.Output)
+	if err != nil {
+		return nil, err
+	}
+	s.reader = reader
+	s.modTime = info.ModTime()
+	meta, err := reader.Metadata()
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	if s.doc, err = tileutils.TileJSONDocumentFromMetadata(meta, "", args.PublicKeys); err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("error reading tilejson from %s: %w", args.Output, err)
+	}
+	return s, nil
+}
+
+// cacheControl returns the Cache-Control header of the responses
+func (s *outputServer) cacheControl() string {
+	if s.maxAge <= 0 {
+		return "no-cache"
+	}
+	return fmt.Sprintf("public, max-age=%d", int(s.maxAge.Seconds()))
+}
+
+func (s *outputServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.URL.Path == "/tiles.json" {
+		s.serveTileJSON(w, r)
+		return
+	}
+	s.serveTile(w, r)
+}
+
+func (s *outputServer) serveTileJSON(w http.ResponseWriter, r *http.Request) {
+	if s.doc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	doc := *s.doc
+	doc.Tiles = []string{strings.TrimSuffix(requestBaseURL(r, s.baseURL), "/") + "/{z}/{x}/{y}.mvt"}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		slog.Error("error encoding tilejson", "error", err)
+		http.Error(w, "error encoding tilejson", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	s.serveContent(w, r, data, s.modTime)
+}
+
+func (s *outputServer) serveTile(w http.ResponseWriter, r *http.Request) {
+	tc, err := tileutils.ParseTilePath(r.URL.Path)
+	if err != nil || (s.doc != nil && (tc.Z < s.doc.MinZoom || tc.Z > s.doc.MaxZoom)) {
+		http.NotFound(w, r)
+		return
+	}
+	data, modTime, err := s.reader.Read(tc)
+	w.Header().Set("Vary", "Accept-Encoding")
+	if errors.Is(err, tileutils.ErrTileNotFound) || (err == nil && tileutils.IsEmptyTile(data)) {
+		// exports leave out tiles without features unless --write-empty is set, which stores them gzipped
+		w.Header().Set("Cache-Control", s.cacheControl())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		slog.Error("error reading tile", tileutils.TileAttr(tc), "error", err)
+		http.Error(w, "error reading tile", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mvtContentType)
+	if tileutils.IsGzipped(data) {
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+		} else if data, err = tileutils.Gunzip(data); err != nil {
+			slog.Error("error decompressing tile", tileutils.TileAttr(tc), "error", err)
+			http.Error(w, "error decompressing tile", http.StatusInternalServerError)
+			return
+		}
+	}
+	s.serveContent(w, r, data, modTime)
+}
+
+// serveContent writes the response with an ETag of the data and the Cache-Control header.
+// Conditional requests get a 304 Not Modified when the data hasn't changed.
+func (s *outputServer) serveContent(w http.ResponseWriter, r *http.Request, data []byte, modTime time.Time) {
+	sum := sha1.Sum(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Cache-Control", s.cacheControl())
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+}
+
+func serveOutputMain(args ServeOutputArgs) {
+	setupLogging(args.LogArgs)
+	ctx := interruptContext()
+
+	s, err := newOutputServer(args)
+	if err != nil {
+		panic(err)
+	}
+	defer s.reader.Close()
+	if err := listenAndServe(ctx, args.Addr, s); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,165 @@
+package tileutils
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultAdaptiveInterval is the default time between adjustments of an adaptive concurrency limit
+const DefaultAdaptiveInterval = 5 * time.Second
+
+const (
+	// adaptiveTolerance is how much slower than the baseline queries can get before the limit is decreased
+	adaptiveTolerance = 2.0
+	// adaptiveBackoff is the factor the limit is multiplied by when the database is overloaded
+	adaptiveBackoff = 0.75
+	// adaptiveMaxWait is the largest fraction of the query time workers may wait for a connection from the pool
+	adaptiveMaxWait = 0.5
+	// baselineDrift is how fast the baseline latency of a zoom follows slower queries, so it adapts to heavier areas
+	baselineDrift = 0.05
+)
+
+type latencySamples struct {
+	total time.Duration
+	n     int
+}
+
+// ConcurrencyLimiter limits how many tiles are rendered at once. The limit can be adjusted between a minimum
+// and a maximum with additive increase, multiplicative decrease (AIMD) from the observed query latency
+// and the time workers wait for a connection from the pool. Each zoom has its own baseline latency,
+// since tiles at different zooms cost very different amounts.
+// It is safe for concurrent use.
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	min, max int
+	limit    int
+	inFlight int
+	peak     int // most tiles in flight since the last adjustment
+
+	latency  map[int]*latencySamples // query latency at each zoom since the last adjustment
+	baseline map[int]time.Duration
+	wait     time.Duration
+	waits    int
+}
+
+// NewConcurrencyLimiter creates a limiter that starts at min tiles at once. With min equal to max, the limit is fixed.
+func NewConcurrencyLimiter(min, max int) *ConcurrencyLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	l := &ConcurrencyLimiter{
+		min:      min,
+		max:      max,
+		limit:    min,
+		latency:  map[int]*latencySamples{},
+		baseline: map[int]time.Duration{},
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// Acquire blocks until another tile may be rendered
+func (l *ConcurrencyLimiter) Acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+	if l.inFlight > l.peak {
+		l.peak = l.inFlight
+	}
+}
+
+// Release frees the slot taken by Acquire
+func (l *ConcurrencyLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.cond.Signal()
+}
+
+// Limit returns the current number of tiles that may be rendered at once
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// ObserveLatency records the time the queries of a tile at zoom z took
+func (l *ConcurrencyLimiter) ObserveLatency(z int, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.latency[z]
+	if !ok {
+		s = &latencySamples{}
+		l.latency[z] = s
+	}
+	s.total += d
+	s.n++
+}
+
+// ObserveWait records the time a worker waited for a connection from the pool
+func (l *ConcurrencyLimiter) ObserveWait(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.wait += d
+	l.waits++
+}
+
+// Adjust updates the limit from the observations since the last adjustment and returns it.
+// The limit is decreased if queries got much slower than their baseline or workers mostly wait for
+// connections, and increased by one if the workers used the whole limit without slowing the database down.
+func (l *ConcurrencyLimiter) Adjust() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.min == l.max {
+		return l.limit
+	}
+	var ratio float64
+	var n int
+	var latency time.Duration
+	for z, s := range l.latency {
+		avg := s.total / time.Duration(s.n)
+		baseline, ok := l.baseline[z]
+		if !ok || avg < baseline {
+			baseline = avg
+		} else {
+			baseline += time.Duration(float64(avg-baseline) * baselineDrift)
+		}
+		l.baseline[z] = baseline
+		if baseline > 0 {
+			ratio += float64(avg) / float64(baseline) * float64(s.n)
+		} else {
+			ratio += float64(s.n)
+		}
+		n += s.n
+		latency += s.total
+	}
+	if n > 0 {
+		ratio /= float64(n)
+		overloaded := ratio > adaptiveTolerance
+		if l.waits > 0 && latency > 0 {
+			avgWait := l.wait / time.Duration(l.waits)
+			overloaded = overloaded || float64(avgWait) > float64(latency/time.Duration(n))*adaptiveMaxWait
+		}
+		switch {
+		case overloaded:
+			l.limit = int(float64(l.limit) * adaptiveBackoff)
+			if l.limit < l.min {
+				l.limit = l.min
+			}
+		case l.peak >= l.limit && l.limit < l.max:
+			l.limit++
+			l.cond.Broadcast()
+		}
+	}
+	l.latency = map[int]*latencySamples{}
+	l.wait, l.waits = 0, 0
+	l.peak = l.inFlight
+	return l.limit
+}
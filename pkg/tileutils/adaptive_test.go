@@ -0,0 +1,61 @@
+package tileutils
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimiterBlocks(t *testing.T) {
+	l := NewConcurrencyLimiter(1, 1)
+	l.Acquire()
+	var acquired atomic.Bool
+	go func() {
+		l.Acquire()
+		acquired.Store(true)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, acquired.Load())
+	l.Release()
+	assert.Eventually(t, acquired.Load, time.Second, 10*time.Millisecond)
+
+	// a fixed limit never changes
+	l.ObserveLatency(3, time.Hour)
+	assert.Equal(t, 1, l.Adjust())
+}
+
+func TestConcurrencyLimiterAdjust(t *testing.T) {
+	l := NewConcurrencyLimiter(1, 4)
+	assert.Equal(t, 1, l.Limit())
+
+	// nothing observed, nothing changes
+	assert.Equal(t, 1, l.Adjust())
+
+	// the whole limit is used and latency is at the baseline, so it increases
+	l.Acquire()
+	l.ObserveLatency(10, 100*time.Millisecond)
+	assert.Equal(t, 2, l.Adjust())
+	l.Acquire()
+	l.ObserveLatency(10, 110*time.Millisecond)
+	l.ObserveLatency(4, 10*time.Millisecond)
+	assert.Equal(t, 3, l.Adjust())
+
+	// not all of the limit is used, so there's no reason to increase it
+	l.ObserveLatency(10, 100*time.Millisecond)
+	assert.Equal(t, 3, l.Adjust())
+
+	// queries got much slower than the baseline of their zoom
+	l.ObserveLatency(10, 400*time.Millisecond)
+	assert.Equal(t, 2, l.Adjust())
+
+	// workers wait for connections most of the time
+	l.ObserveLatency(10, 100*time.Millisecond)
+	l.ObserveWait(80 * time.Millisecond)
+	assert.Equal(t, 1, l.Adjust())
+
+	// never below the minimum
+	l.ObserveLatency(10, time.Second)
+	assert.Equal(t, 1, l.Adjust())
+}
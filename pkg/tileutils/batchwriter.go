@@ -0,0 +1,135 @@
+package tileutils
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/twpayne/go-mbtiles"
+)
+
+const (
+	// DefaultBatchSize is the default number of tiles committed in one transaction by a BatchWriter
+	DefaultBatchSize = 1000
+	// DefaultBatchDuration is the default longest time a tile waits in a BatchWriter before it is committed
+	DefaultBatchDuration = 2 * time.Second
+)
+
+// BatchWriterOptions configures a BatchWriter
+type BatchWriterOptions struct {
+	Size     int           // most tiles in a batch, also the number of tiles queued before writes block
+	Duration time.Duration // longest a tile waits before its batch is written
+	Order    TileOrder     // order tiles are sorted in within a batch
+}
+
+// BatchWriter queues tiles on a bounded channel and writes them from a single goroutine, in batches of
+// up to Size tiles or whatever is queued after Duration. Writes block once the queue is full.
+// The output is only ever written from one goroutine, so there's no contention for sqlite's write lock.
+type BatchWriter struct {
+	writer TileBulkWriter
+	opts   BatchWriterOptions
+	queue  chan mbtiles.TileData
+	done   chan struct{}
+
+	mu  sync.Mutex
+	err error // first error writing a batch
+}
+
+// NewBatchWriter starts writing batches of tiles to w
+func NewBatchWriter(w TileBulkWriter, opts BatchWriterOptions) *BatchWriter {
+	if opts.Size < 1 {
+		opts.Size = DefaultBatchSize
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = DefaultBatchDuration
+	}
+	b := &BatchWriter{
+		writer: w,
+		opts:   opts,
+		queue:  make(chan mbtiles.TileData, opts.Size),
+		done:   make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Write queues a tile. It doesn't report errors writing the tile, see Close.
+func (b *BatchWriter) Write(z, x, y int, tileData []byte) error {
+	b.queue <- mbtiles.TileData{Z: z, X: x, Y: y, Data: tileData}
+	return nil
+}
+
+// BulkWrite queues the tiles
+func (b *BatchWriter) BulkWrite(data []mbtiles.TileData) error {
+	for _, td := range data {
+		b.queue <- td
+	}
+	return nil
+}
+
+func (b *BatchWriter) New() (TileWriter, func(), error) {
+	return b, func() { b.Close() }, nil
+}
+
+// Close writes the queued tiles, stops the writer goroutine and returns the first error writing a batch.
+// Nothing may be written after Close.
+func (b *BatchWriter) Close() error {
+	close(b.queue)
+	<-b.done
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+func (b *BatchWriter) run() {
+	defer close(b.done)
+	batch := make([]mbtiles.TileData, 0, b.opts.Size)
+	timer := time.NewTimer(b.opts.Duration)
+	stopTimer(timer)
+	for {
+		select {
+		case td, ok := <-b.queue:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(b.opts.Duration)
+			}
+			batch = append(batch, td)
+			if len(batch) == b.opts.Size {
+				stopTimer(timer)
+				batch = b.flush(batch)
+			}
+		case <-timer.C:
+			batch = b.flush(batch)
+		}
+	}
+}
+
+// flush writes the batch and returns it emptied
+func (b *BatchWriter) flush(batch []mbtiles.TileData) []mbtiles.TileData {
+	if len(batch) == 0 {
+		return batch
+	}
+	SortTileData(batch, b.opts.Order)
+	if err := b.writer.BulkWrite(batch); err != nil {
+		slog.Error("error writing tiles", "tiles", len(batch), "error", err)
+		b.mu.Lock()
+		if b.err == nil {
+			b.err = err
+		}
+		b.mu.Unlock()
+	}
+	return batch[:0]
+}
+
+// stopTimer stops the timer and drains its channel, so it can be reset
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
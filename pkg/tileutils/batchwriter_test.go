@@ -0,0 +1,76 @@
+package tileutils
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twpayne/go-mbtiles"
+)
+
+// batchRecorder records the size of each batch written
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]TileCoords
+	err     error
+}
+
+func (w *batchRecorder) BulkWrite(data []mbtiles.TileData) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	batch := make([]TileCoords, len(data))
+	for i, td := range data {
+		batch[i] = TileCoords{Z: td.Z, X: td.X, Y: td.Y}
+	}
+	w.batches = append(w.batches, batch)
+	return w.err
+}
+
+func (w *batchRecorder) sizes() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sizes := make([]int, len(w.batches))
+	for i, b := range w.batches {
+		sizes[i] = len(b)
+	}
+	return sizes
+}
+
+func TestBatchWriter(t *testing.T) {
+	rec := &batchRecorder{}
+	b := NewBatchWriter(rec, BatchWriterOptions{Size: 3, Duration: time.Hour, Order: TileOrderMorton})
+	for x := 4; x >= 1; x-- {
+		require.Nil(t, b.Write(3, x, 0, nil))
+	}
+	require.Nil(t, b.BulkWrite([]mbtiles.TileData{{Z: 3, X: 7}}))
+	// a full batch is written right away, the rest on close
+	assert.Eventually(t, func() bool { return len(rec.sizes()) == 1 }, time.Second, time.Millisecond)
+	require.Nil(t, b.Close())
+	assert.Equal(t, []int{3, 2}, rec.sizes())
+	// each batch is sorted
+	assert.Equal(t, []TileCoords{{Z: 3, X: 2}, {Z: 3, X: 3}, {Z: 3, X: 4}}, rec.batches[0])
+}
+
+func TestBatchWriterDuration(t *testing.T) {
+	rec := &batchRecorder{}
+	b := NewBatchWriter(rec, BatchWriterOptions{Size: 100, Duration: 20 * time.Millisecond})
+	require.Nil(t, b.Write(1, 0, 0, nil))
+	require.Nil(t, b.Write(1, 1, 0, nil))
+	assert.Eventually(t, func() bool { return len(rec.sizes()) == 1 }, time.Second, time.Millisecond)
+	require.Nil(t, b.Write(1, 1, 1, nil))
+	assert.Eventually(t, func() bool { return len(rec.sizes()) == 2 }, time.Second, time.Millisecond)
+	require.Nil(t, b.Close())
+	assert.Equal(t, []int{2, 1}, rec.sizes())
+}
+
+func TestBatchWriterError(t *testing.T) {
+	rec := &batchRecorder{err: errors.New("disk full")}
+	b := NewBatchWriter(rec, BatchWriterOptions{Size: 1})
+	require.Nil(t, b.Write(0, 0, 0, nil))
+	require.Nil(t, b.Write(1, 0, 0, nil))
+	assert.ErrorContains(t, b.Close(), "disk full")
+	assert.Equal(t, []int{1, 1}, rec.sizes())
+}
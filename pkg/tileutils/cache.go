@@ -0,0 +1,73 @@
+package tileutils
+
+import (
+	"container/list"
+	"sync"
+)
+
+// TileCache is an in-memory LRU cache of tiles, holding at most a fixed number of tiles.
+// It is safe for concurrent use.
+type TileCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // most recently used first
+	entries  map[TileCoords]*list.Element
+}
+
+type cacheEntry struct {
+	tile TileCoords
+	data []byte
+}
+
+// NewTileCache creates a cache of up to capacity tiles
+func NewTileCache(capacity int) *TileCache {
+	return &TileCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[TileCoords]*list.Element),
+	}
+}
+
+// Get returns the cached data of a tile and marks it as recently used
+func (c *TileCache) Get(tc TileCoords) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[tc]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+// Add caches the data of a tile, evicting the least recently used tile when the cache is full
+func (c *TileCache) Add(tc TileCoords, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[tc]; ok {
+		e.Value.(*cacheEntry).data = data
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[tc] = c.order.PushFront(&cacheEntry{tile: tc, data: data})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).tile)
+	}
+}
+
+// Len returns the number of cached tiles
+func (c *TileCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Clear removes every tile from the cache
+func (c *TileCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[TileCoords]*list.Element)
+}
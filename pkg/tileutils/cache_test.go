@@ -0,0 +1,33 @@
+package tileutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTileCache(t *testing.T) {
+	c := NewTileCache(2)
+	a, b, d := TileCoords{Z: 1}, TileCoords{Z: 2}, TileCoords{Z: 3}
+	c.Add(a, []byte("a"))
+	c.Add(b, []byte("b"))
+	// a is used last, so b is evicted
+	data, ok := c.Get(a)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), data)
+	c.Add(d, []byte("d"))
+	_, ok = c.Get(b)
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	// replacing a tile keeps one entry
+	c.Add(d, []byte("d2"))
+	data, _ = c.Get(d)
+	assert.Equal(t, []byte("d2"), data)
+	assert.Equal(t, 2, c.Len())
+
+	c.Clear()
+	assert.Equal(t, 0, c.Len())
+	_, ok = c.Get(a)
+	assert.False(t, ok)
+}
@@ -0,0 +1,50 @@
+package tileutils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TileSet is a set of tile coordinates
+type TileSet map[TileCoords]struct{}
+
+// Add inserts a tile into the set
+func (ts TileSet) Add(tc TileCoords) {
+	ts[tc] = struct{}{}
+}
+
+// Contains checks if a tile is in the set
+func (ts TileSet) Contains(tc TileCoords) bool {
+	_, ok := ts[tc]
+	return ok
+}
+
+// CoverageQuery builds a query which lists the tiles at the given zoom, within the bounding box,
+// that contain any geometry returned by the layer query. The result has two columns: x and y.
+// Each cell is checked with an EXISTS against the layer's spatial index, so the geometries themselves
+// are never rendered. The tile envelope includes the same margin used when rendering tiles.
+func CoverageQuery(query string, zoom int, bbox BoundingBox) string {
+	xMin, xMax, yMin, yMax := tileRange(bbox, zoom)
+	template := "SELECT x, y FROM generate_series(%d, %d) AS x, generate_series(%d, %d) AS y " +
+		"WHERE EXISTS (SELECT 1 FROM (%s) AS t WHERE t.geom && ST_TileEnvelope(%d, x, y, margin => (64.0/4096)))"
+	return fmt.Sprintf(template,
+		xMin, xMax, yMin, yMax,
+		strings.ReplaceAll(query, ";", ""),
+		zoom)
+}
+
+// FilterCoverage splits the tiles into the ones to export and the ones that can be skipped because
+// they have no source data. coverage maps each zoom to the set of tiles at coverageZoom which contain data.
+// Tiles are kept if their ancestor at coverageZoom is in the set for their zoom.
+// Tiles below the coverage zoom are always kept.
+func FilterCoverage(tiles []TileCoords, coverage map[int]TileSet, coverageZoom int) (kept []TileCoords, skipped []TileCoords) {
+	kept = make([]TileCoords, 0, len(tiles))
+	for _, tc := range tiles {
+		if tc.Z < coverageZoom || coverage[tc.Z].Contains(tc.Ancestor(coverageZoom)) {
+			kept = append(kept, tc)
+			continue
+		}
+		skipped = append(skipped, tc)
+	}
+	return kept, skipped
+}
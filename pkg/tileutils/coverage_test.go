@@ -0,0 +1,43 @@
+package tileutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAncestor(t *testing.T) {
+	tc := TileCoords{Z: 14, X: 2816, Y: 6547}
+	assert.Equal(t, TileCoords{Z: 10, X: 176, Y: 409}, tc.Ancestor(10))
+	assert.Equal(t, TileCoords{Z: 0, X: 0, Y: 0}, tc.Ancestor(0))
+	assert.Equal(t, tc, tc.Ancestor(14))
+	assert.Equal(t, tc, tc.Ancestor(16))
+}
+
+func TestCoverageQuery(t *testing.T) {
+	sql := CoverageQuery("SELECT id, tags, geom FROM osm_ocean;", 2, BoundingBox{
+		Left:   -180,
+		Right:  180,
+		Top:    85,
+		Bottom: -85,
+	})
+	assert.Contains(t, sql, "generate_series(0, 3) AS x, generate_series(0, 3) AS y")
+	assert.Contains(t, sql, "FROM (SELECT id, tags, geom FROM osm_ocean) AS t")
+	assert.Contains(t, sql, "ST_TileEnvelope(2, x, y")
+}
+
+func TestFilterCoverage(t *testing.T) {
+	coverage := map[int]TileSet{
+		4: {{Z: 2, X: 1, Y: 1}: {}},
+		5: {},
+	}
+	tiles := []TileCoords{
+		{Z: 1, X: 1, Y: 1}, // below the coverage zoom
+		{Z: 4, X: 5, Y: 6}, // ancestor is 2/1/1
+		{Z: 4, X: 8, Y: 6}, // ancestor is 2/2/1
+		{Z: 5, X: 10, Y: 12},
+	}
+	kept, skipped := FilterCoverage(tiles, coverage, 2)
+	assert.Equal(t, []TileCoords{tiles[0], tiles[1]}, kept)
+	assert.Equal(t, []TileCoords{tiles[2], tiles[3]}, skipped)
+}
@@ -0,0 +1,138 @@
+package tileutils
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/twpayne/go-mbtiles"
+)
+
+// TileOrder is the order tiles are queried and written in
+type TileOrder string
+
+const (
+	// TileOrderSteps keeps the order from ListTiles, where each zoom is split into a 4x4 grid of column-major steps
+	TileOrderSteps TileOrder = "steps"
+	// TileOrderHilbert orders the tiles of each zoom along a Hilbert curve
+	TileOrderHilbert TileOrder = "hilbert"
+	// TileOrderMorton orders the tiles of each zoom along a Morton (Z-order) curve
+	TileOrderMorton TileOrder = "morton"
+)
+
+// ParseTileOrder validates a tile order name
+func ParseTileOrder(s string) (TileOrder, error) {
+	switch order := TileOrder(s); order {
+	case TileOrderSteps, TileOrderHilbert, TileOrderMorton:
+		return order, nil
+	}
+	return "", fmt.Errorf("unknown tile order (%s), expected one of: steps, hilbert, morton", s)
+}
+
+// HilbertIndex returns the distance of the tile along the Hilbert curve which fills its zoom level
+func HilbertIndex(z, x, y int) uint64 {
+	n := uint64(1) << z
+	ux, uy := uint64(x), uint64(y)
+	var d uint64
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint64
+		if ux&s > 0 {
+			rx = 1
+		}
+		if uy&s > 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+		// rotate the quadrant so the curve stays continuous
+		if ry == 0 {
+			if rx == 1 {
+				ux = n - 1 - ux
+				uy = n - 1 - uy
+			}
+			ux, uy = uy, ux
+		}
+	}
+	return d
+}
+
+// spreadBits moves the lower 32 bits of v into the even bits of the result
+func spreadBits(v uint64) uint64 {
+	v &= 0xFFFFFFFF
+	v = (v | v<<16) & 0x0000FFFF0000FFFF
+	v = (v | v<<8) & 0x00FF00FF00FF00FF
+	v = (v | v<<4) & 0x0F0F0F0F0F0F0F0F
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// MortonIndex returns the position of the tile along the Morton (Z-order) curve, by interleaving the bits of x and y
+func MortonIndex(x, y int) uint64 {
+	return spreadBits(uint64(x)) | spreadBits(uint64(y))<<1
+}
+
+// CurveIndex returns the position of the tile along the curve used by the order.
+// The steps order has no curve, so it always returns 0.
+func (tc TileCoords) CurveIndex(order TileOrder) uint64 {
+	switch order {
+	case TileOrderHilbert:
+		return HilbertIndex(tc.Z, tc.X, tc.Y)
+	case TileOrderMorton:
+		return MortonIndex(tc.X, tc.Y)
+	}
+	return 0
+}
+
+// curveKey orders tiles by zoom, then by position along a curve
+type curveKey struct {
+	z     int
+	index uint64
+}
+
+func (k curveKey) less(o curveKey) bool {
+	if k.z != o.z {
+		return k.z < o.z
+	}
+	return k.index < o.index
+}
+
+// sortByCurve sorts items by the curve keys of their tiles, calling swap to move the items
+func sortByCurve(keys []curveKey, swap func(i, j int)) {
+	sort.Sort(curveSorter{keys: keys, swap: swap})
+}
+
+type curveSorter struct {
+	keys []curveKey
+	swap func(i, j int)
+}
+
+func (s curveSorter) Len() int           { return len(s.keys) }
+func (s curveSorter) Less(i, j int) bool { return s.keys[i].less(s.keys[j]) }
+func (s curveSorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.swap(i, j)
+}
+
+// SortTiles sorts the tiles by zoom, then by their position along the order's curve.
+// The steps order leaves the tiles untouched.
+func SortTiles(tiles []TileCoords, order TileOrder) {
+	if order != TileOrderHilbert && order != TileOrderMorton {
+		return
+	}
+	keys := make([]curveKey, len(tiles))
+	for i, tc := range tiles {
+		keys[i] = curveKey{z: tc.Z, index: tc.CurveIndex(order)}
+	}
+	sortByCurve(keys, func(i, j int) { tiles[i], tiles[j] = tiles[j], tiles[i] })
+}
+
+// SortTileData sorts a batch of tiles before it is written, using the same ordering as SortTiles
+func SortTileData(data []mbtiles.TileData, order TileOrder) {
+	if order != TileOrderHilbert && order != TileOrderMorton {
+		return
+	}
+	keys := make([]curveKey, len(data))
+	for i, d := range data {
+		keys[i] = curveKey{z: d.Z, index: TileCoords{Z: d.Z, X: d.X, Y: d.Y}.CurveIndex(order)}
+	}
+	sortByCurve(keys, func(i, j int) { data[i], data[j] = data[j], data[i] })
+}
@@ -0,0 +1,102 @@
+package tileutils
+
+import (
+	"container/list"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twpayne/go-mbtiles"
+)
+
+func TestHilbertIndex(t *testing.T) {
+	assert.Equal(t, uint64(0), HilbertIndex(1, 0, 0))
+	assert.Equal(t, uint64(1), HilbertIndex(1, 0, 1))
+	assert.Equal(t, uint64(2), HilbertIndex(1, 1, 1))
+	assert.Equal(t, uint64(3), HilbertIndex(1, 1, 0))
+
+	// every step along the curve moves to a neighboring tile
+	tiles := tilesInBbox(BoundingBox{Left: -180, Right: 180, Top: 85, Bottom: -85}, 5)
+	SortTiles(tiles, TileOrderHilbert)
+	for i := 1; i < len(tiles); i++ {
+		dx := tiles[i].X - tiles[i-1].X
+		dy := tiles[i].Y - tiles[i-1].Y
+		assert.Equal(t, 1, dx*dx+dy*dy, "%v -> %v", tiles[i-1], tiles[i])
+	}
+}
+
+func TestMortonIndex(t *testing.T) {
+	assert.Equal(t, uint64(0), MortonIndex(0, 0))
+	assert.Equal(t, uint64(1), MortonIndex(1, 0))
+	assert.Equal(t, uint64(2), MortonIndex(0, 1))
+	assert.Equal(t, uint64(3), MortonIndex(1, 1))
+	assert.Equal(t, uint64(0b101010), MortonIndex(0, 7))
+}
+
+func TestSortTiles(t *testing.T) {
+	tiles := []TileCoords{
+		{Z: 2, X: 1, Y: 1},
+		{Z: 1, X: 1, Y: 0},
+		{Z: 1, X: 0, Y: 1},
+	}
+	SortTiles(tiles, TileOrderHilbert)
+	assert.Equal(t, []TileCoords{{Z: 1, X: 0, Y: 1}, {Z: 1, X: 1, Y: 0}, {Z: 2, X: 1, Y: 1}}, tiles)
+
+	data := []mbtiles.TileData{{Z: 1, X: 1, Y: 1}, {Z: 1, X: 1, Y: 0}, {Z: 1, X: 0, Y: 0}}
+	SortTileData(data, TileOrderMorton)
+	assert.Equal(t, []mbtiles.TileData{{Z: 1, X: 0, Y: 0}, {Z: 1, X: 1, Y: 0}, {Z: 1, X: 1, Y: 1}}, data)
+
+	_, err := ParseTileOrder("random")
+	assert.NotNil(t, err)
+}
+
+// lruHitRate replays the page accesses through a LRU cache of the given size and returns the hit rate
+func lruHitRate(pages []uint64, size int) float64 {
+	order := list.New()
+	cache := map[uint64]*list.Element{}
+	hits := 0
+	for _, p := range pages {
+		if e, ok := cache[p]; ok {
+			hits++
+			order.MoveToFront(e)
+			continue
+		}
+		cache[p] = order.PushFront(p)
+		if order.Len() > size {
+			oldest := order.Back()
+			order.Remove(oldest)
+			delete(cache, oldest.Value.(uint64))
+		}
+	}
+	return float64(hits) / float64(len(pages))
+}
+
+// BenchmarkTileOrderCacheHitRate compares how well each ordering reuses cached pages.
+// The spatial index is modelled with one page per 16x16 block of tiles, and the sqlite
+// tiles B-tree with pages of 64 consecutive (zoom, column, row) keys.
+func BenchmarkTileOrderCacheHitRate(b *testing.B) {
+	const zoom = 9
+	const cacheSize = 8
+	bbox := BoundingBox{Left: -180, Right: 180, Top: 85, Bottom: -85}
+	rowsPerColumn := uint64(1) << zoom
+
+	for _, order := range []TileOrder{TileOrderSteps, TileOrderHilbert, TileOrderMorton} {
+		b.Run(string(order), func(b *testing.B) {
+			var spatialHits, btreeHits float64
+			for i := 0; i < b.N; i++ {
+				tiles := tilesInBbox(bbox, zoom)
+				SortTiles(tiles, order)
+				spatialPages := make([]uint64, len(tiles))
+				btreePages := make([]uint64, len(tiles))
+				for j, tc := range tiles {
+					block := tc.Ancestor(zoom - 4)
+					spatialPages[j] = uint64(block.X)<<32 | uint64(block.Y)
+					btreePages[j] = (uint64(tc.X)*rowsPerColumn + uint64(tc.Y)) / 64
+				}
+				spatialHits = lruHitRate(spatialPages, cacheSize)
+				btreeHits = lruHitRate(btreePages, cacheSize)
+			}
+			b.ReportMetric(spatialHits*100, "spatial-hit%")
+			b.ReportMetric(btreeHits*100, "btree-hit%")
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package tileutils
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/twpayne/go-mbtiles"
+)
+
+// zoomExtent is the range of tiles with features written at one zoom
+type zoomExtent struct {
+	count      int // tiles written, including empty ones
+	features   int // tiles written with features, which the range covers
+	xMin, xMax int
+	yMin, yMax int
+}
+
+// TileExtent tracks what was actually written: the zooms, the area covered and the number of tiles at each zoom.
+// Empty tiles are counted, but don't widen the zooms or the area, since they only fill in where there is no data.
+// It is safe for concurrent use.
+type TileExtent struct {
+	mu    sync.Mutex
+	zooms map[int]*zoomExtent
+}
+
+// NewTileExtent creates an empty TileExtent
+func NewTileExtent() *TileExtent {
+	return &TileExtent{
+		zooms: map[int]*zoomExtent{},
+	}
+}
+
+// zoom returns the extent of zoom z, adding it if needed
+func (e *TileExtent) zoom(z int) *zoomExtent {
+	ze, ok := e.zooms[z]
+	if !ok {
+		ze = &zoomExtent{}
+		e.zooms[z] = ze
+	}
+	return ze
+}
+
+// AddEmpty records a written tile without features
+func (e *TileExtent) AddEmpty(tc TileCoords) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.zoom(tc.Z).count++
+}
+
+// Add records a written tile with features
+func (e *TileExtent) Add(tc TileCoords) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ze := e.zoom(tc.Z)
+	ze.count++
+	ze.features++
+	if ze.features == 1 {
+		ze.xMin, ze.xMax, ze.yMin, ze.yMax = tc.X, tc.X, tc.Y, tc.Y
+		return
+	}
+	if tc.X < ze.xMin {
+		ze.xMin = tc.X
+	}
+	if tc.X > ze.xMax {
+		ze.xMax = tc.X
+	}
+	if tc.Y < ze.yMin {
+		ze.yMin = tc.Y
+	}
+	if tc.Y > ze.yMax {
+		ze.yMax = tc.Y
+	}
+}
+
+// zoomExtentJSON is a zoomExtent as saved with the export journal
+type zoomExtentJSON struct {
+	Zoom     int `json:"zoom"`
+	Count    int `json:"count"`
+	Features int `json:"features"`
+	XMin     int `json:"x_min"`
+	XMax     int `json:"x_max"`
+	YMin     int `json:"y_min"`
+	YMax     int `json:"y_max"`
+}
+
+// MarshalJSON saves the extent, so a resumed export can start from it
+func (e *TileExtent) MarshalJSON() ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]zoomExtentJSON, 0, len(e.zooms))
+	for z, ze := range e.zooms {
+		out = append(out, zoomExtentJSON{Zoom: z, Count: ze.count, Features: ze.features, XMin: ze.xMin, XMax: ze.xMax, YMin: ze.yMin, YMax: ze.yMax})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Zoom < out[j].Zoom })
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON replaces the extent with one saved by MarshalJSON
+func (e *TileExtent) UnmarshalJSON(data []byte) error {
+	var in []zoomExtentJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	zooms := make(map[int]*zoomExtent, len(in))
+	for _, ze := range in {
+		zooms[ze.Zoom] = &zoomExtent{count: ze.Count, features: ze.Features, xMin: ze.XMin, xMax: ze.XMax, yMin: ze.YMin, yMax: ze.YMax}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.zooms = zooms
+	return nil
+}
+
+// Counts returns the number of tiles written at each zoom
+func (e *TileExtent) Counts() map[int]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	counts := make(map[int]int, len(e.zooms))
+	for z, ze := range e.zooms {
+		counts[z] = ze.count
+	}
+	return counts
+}
+
+// Zooms returns the lowest and highest zoom written with features. ok is false if there are none.
+func (e *TileExtent) Zooms() (minZoom, maxZoom int, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for z, ze := range e.zooms {
+		if ze.features == 0 {
+			continue
+		}
+		if !ok || z < minZoom {
+			minZoom = z
+		}
+		if !ok || z > maxZoom {
+			maxZoom = z
+		}
+		ok = true
+	}
+	return
+}
+
+// Bounds returns the [left, bottom, right, top] bounds covering every written tile with features,
+// or nil if there are none
+func (e *TileExtent) Bounds() []float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var bounds []float64
+	for z, ze := range e.zooms {
+		if ze.features == 0 {
+			continue
+		}
+		b := []float64{xToLon(ze.xMin, z), yToLat(ze.yMax+1, z), xToLon(ze.xMax+1, z), yToLat(ze.yMin, z)}
+		if bounds == nil {
+			bounds = b
+			continue
+		}
+		bounds[0] = math.Min(bounds[0], b[0])
+		bounds[1] = math.Min(bounds[1], b[1])
+		bounds[2] = math.Max(bounds[2], b[2])
+		bounds[3] = math.Max(bounds[3], b[3])
+	}
+	return bounds
+}
+
+// Apply returns a copy of the TileJSON with the zooms and bounds of what was written, so the metadata
+// describes the output rather than the input. The bounds stay within the input bounds, since the tiles
+// at low zooms cover much more than the data. The center is moved inside the new bounds if needed.
+// If nothing with features was written, the TileJSON is returned unchanged.
+func (e *TileExtent) Apply(tj *TileJSON) *TileJSON {
+	minZoom, maxZoom, ok := e.Zooms()
+	if !ok {
+		return tj
+	}
+	out := *tj
+	out.MinZoom = minZoom
+	out.MaxZoom = maxZoom
+	out.Bounds = e.Bounds()
+	if input := tj.UnionBounds(); input != nil {
+		if bounds, ok := intersectBounds(out.Bounds, input); ok {
+			out.Bounds = bounds
+		}
+	}
+	out.ZoomBounds = nil
+	if len(out.Center) >= 2 {
+		lon, lat := out.Center[0], out.Center[1]
+		if lon < out.Bounds[0] || lon > out.Bounds[2] || lat < out.Bounds[1] || lat > out.Bounds[3] {
+			out.Center = nil
+		}
+	}
+	if out.Center == nil {
+		out.Center = []float64{(out.Bounds[0] + out.Bounds[2]) / 2, (out.Bounds[1] + out.Bounds[3]) / 2, float64(minZoom)}
+	} else if len(out.Center) == 3 {
+		out.Center = []float64{out.Center[0], out.Center[1], math.Max(float64(minZoom), math.Min(float64(maxZoom), out.Center[2]))}
+	}
+	return &out
+}
+
+// intersectBounds returns the overlap of two [left, bottom, right, top] bounds. ok is false if they don't overlap.
+func intersectBounds(a, b []float64) (bounds []float64, ok bool) {
+	bounds = []float64{math.Max(a[0], b[0]), math.Max(a[1], b[1]), math.Min(a[2], b[2]), math.Min(a[3], b[3])}
+	if bounds[0] >= bounds[2] || bounds[1] >= bounds[3] {
+		return nil, false
+	}
+	return bounds, true
+}
+
+// ExtentWriter records the tiles written through Writer and BulkWriter in Extent
+type ExtentWriter struct {
+	Writer     TileWriter
+	BulkWriter TileBulkWriter
+	Extent     *TileExtent
+}
+
+// add records a written tile, telling empty tiles apart
+func (w *ExtentWriter) add(tc TileCoords, tileData []byte) {
+	if IsEmptyTile(tileData) {
+		w.Extent.AddEmpty(tc)
+		return
+	}
+	w.Extent.Add(tc)
+}
+
+func (w *ExtentWriter) Write(z, x, y int, tileData []byte) error {
+	if err := w.Writer.Write(z, x, y, tileData); err != nil {
+		return err
+	}
+	w.add(TileCoords{Z: z, X: x, Y: y}, tileData)
+	return nil
+}
+
+func (w *ExtentWriter) BulkWrite(data []mbtiles.TileData) error {
+	if err := w.BulkWriter.BulkWrite(data); err != nil {
+		return err
+	}
+	for _, td := range data {
+		w.add(TileCoords{Z: td.Z, X: td.X, Y: td.Y}, td.Data)
+	}
+	return nil
+}
+
+func (w *ExtentWriter) New() (TileWriter, func(), error) {
+	return w, func() {}, nil
+}
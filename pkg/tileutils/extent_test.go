@@ -0,0 +1,92 @@
+package tileutils
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twpayne/go-mbtiles"
+)
+
+type recordingWriter struct {
+	tiles []TileCoords
+}
+
+func (w *recordingWriter) Write(z, x, y int, tileData []byte) error {
+	w.tiles = append(w.tiles, TileCoords{Z: z, X: x, Y: y})
+	return nil
+}
+
+func (w *recordingWriter) BulkWrite(data []mbtiles.TileData) error {
+	for _, td := range data {
+		w.tiles = append(w.tiles, TileCoords{Z: td.Z, X: td.X, Y: td.Y})
+	}
+	return nil
+}
+
+func (w *recordingWriter) New() (TileWriter, func(), error) {
+	return w, func() {}, nil
+}
+
+func TestTileExtent(t *testing.T) {
+	e := NewTileExtent()
+	_, _, ok := e.Zooms()
+	assert.False(t, ok)
+	assert.Nil(t, e.Bounds())
+	tj := &TileJSON{MinZoom: 0, MaxZoom: 14, Bounds: []float64{-180, -85, 180, 85}, Center: []float64{10, 10, 13}}
+	assert.Equal(t, tj, e.Apply(tj))
+
+	rw := &recordingWriter{}
+	w := &ExtentWriter{Writer: rw, BulkWriter: rw, Extent: e}
+	data := []byte{0x1a, 0x00}
+	empty, err := Gzip([]byte{})
+	require.Nil(t, err)
+	// the north west quarter at zoom 1, then a tile from a --file list at zoom 3
+	require.Nil(t, w.Write(1, 0, 0, data))
+	require.Nil(t, w.BulkWrite([]mbtiles.TileData{{Z: 3, X: 4, Y: 4, Data: data}, {Z: 3, X: 4, Y: 5, Data: data}}))
+	// empty tiles are counted, but don't widen the zooms or the bounds
+	require.Nil(t, w.Write(0, 0, 0, empty))
+	require.Nil(t, w.BulkWrite([]mbtiles.TileData{{Z: 5, X: 0, Y: 31, Data: empty}}))
+	assert.Len(t, rw.tiles, 5)
+
+	assert.Equal(t, map[int]int{0: 1, 1: 1, 3: 2, 5: 1}, e.Counts())
+	minZoom, maxZoom, ok := e.Zooms()
+	assert.True(t, ok)
+	assert.Equal(t, 1, minZoom)
+	assert.Equal(t, 3, maxZoom)
+	bounds := e.Bounds()
+	require.Len(t, bounds, 4)
+	assert.InDelta(t, -180, bounds[0], 1e-9)
+	assert.InDelta(t, -66.513260, bounds[1], 1e-6)
+	assert.InDelta(t, 45, bounds[2], 1e-9)
+	assert.InDelta(t, 85.051129, bounds[3], 1e-6)
+
+	written := e.Apply(tj)
+	assert.Equal(t, 1, written.MinZoom)
+	assert.Equal(t, 3, written.MaxZoom)
+	// kept within the input bounds
+	assert.Equal(t, []float64{-180, -66.513260, 45, 85}, roundBounds(written.Bounds))
+	assert.Equal(t, []float64{10, 10, 3}, written.Center)
+	// the input is left alone
+	assert.Equal(t, 14, tj.MaxZoom)
+}
+
+func roundBounds(b []float64) []float64 {
+	out := make([]float64, len(b))
+	for i, v := range b {
+		out[i] = math.Round(v*1e6) / 1e6
+	}
+	return out
+}
+
+func TestIsEmptyTile(t *testing.T) {
+	assert.True(t, IsEmptyTile(nil))
+	empty, err := Gzip([]byte{})
+	require.Nil(t, err)
+	assert.True(t, IsEmptyTile(empty))
+	data, err := Gzip([]byte{0x1a, 0x00})
+	require.Nil(t, err)
+	assert.False(t, IsEmptyTile(data))
+	assert.False(t, IsEmptyTile([]byte{0x1a, 0x00}))
+}
@@ -2,6 +2,7 @@ package tileutils
 
 import (
 	"bytes"
+	"io"
 
 	gziplib "github.com/klauspost/compress/gzip"
 )
@@ -21,3 +22,36 @@ func Gzip(data []byte) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// maxEmptyGzipSize is larger than any gzip stream of zero bytes
+const maxEmptyGzipSize = 64
+
+// IsEmptyTile reports whether stored tile data has no features: either no bytes, or gzipped zero bytes
+func IsEmptyTile(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	if !IsGzipped(data) || len(data) > maxEmptyGzipSize {
+		return false
+	}
+	tile, err := Gunzip(data)
+	return err == nil && len(tile) == 0
+}
+
+// IsGzipped reports whether the data starts with the gzip magic number
+func IsGzipped(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// Gunzip unzips a stored tile. Data that isn't gzipped is returned as is.
+func Gunzip(data []byte) ([]byte, error) {
+	if !IsGzipped(data) {
+		return data, nil
+	}
+	r, err := gziplib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
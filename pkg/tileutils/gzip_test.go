@@ -23,3 +23,19 @@ func TestGzip(t *testing.T) {
 	require.Nil(t, err)
 	assert.Equal(t, data, input)
 }
+
+func TestGunzip(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5}
+	zipped, err := Gzip(data)
+	require.Nil(t, err)
+	assert.True(t, IsGzipped(zipped))
+	assert.False(t, IsGzipped(data))
+	output, err := Gunzip(zipped)
+	require.Nil(t, err)
+	assert.Equal(t, data, output)
+
+	// uncompressed tiles are passed through
+	output, err = Gunzip(data)
+	require.Nil(t, err)
+	assert.Equal(t, data, output)
+}
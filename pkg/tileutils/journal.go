@@ -0,0 +1,256 @@
+package tileutils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/twpayne/go-mbtiles"
+)
+
+// journalTable records the tiles of an export that are committed to the mbtiles, so an interrupted
+// export can be resumed. Coordinates are in the z/x/y scheme, not the TMS rows of the tiles table.
+const journalTable = "export_journal"
+
+const createJournalSQL = "CREATE TABLE IF NOT EXISTS " + journalTable +
+	" (z INTEGER NOT NULL, x INTEGER NOT NULL, y INTEGER NOT NULL, PRIMARY KEY (z, x, y)) WITHOUT ROWID"
+
+// stateTable keeps what an interrupted export knows about the tiles it wrote, next to the journal,
+// so resuming doesn't have to read every tile back
+const stateTable = "export_state"
+
+const createStateSQL = "CREATE TABLE IF NOT EXISTS " + stateTable + " (name TEXT NOT NULL PRIMARY KEY, value BLOB NOT NULL)"
+
+// ExportState is the state of an interrupted export saved with the journal
+type ExportState struct {
+	Tiles  int             `json:"tiles"` // number of tiles in the journal when the state was saved
+	Extent *TileExtent     `json:"extent"`
+	Layers *LayerCollector `json:"layers,omitempty"`
+}
+
+// tileKey numbers tiles in one integer, ordered by zoom, then column, then row. The tiles of zoom z
+// come after the (4^z-1)/3 tiles of the zooms above it, which leaves every zoom up to 31 its own range.
+func tileKey(tc TileCoords) uint64 {
+	z := uint(tc.Z)
+	return (1<<(2*z)-1)/3 + uint64(tc.X)<<z + uint64(tc.Y)
+}
+
+// DoneTiles is the set of tiles an interrupted export already completed.
+// It is kept as a sorted list of packed coordinates, 8 bytes per tile.
+type DoneTiles struct {
+	keys []uint64
+}
+
+// NewDoneTiles creates a set of the given tiles
+func NewDoneTiles(tiles []TileCoords) *DoneTiles {
+	d := &DoneTiles{keys: make([]uint64, len(tiles))}
+	for i, tc := range tiles {
+		d.keys[i] = tileKey(tc)
+	}
+	sort.Slice(d.keys, func(i, j int) bool { return d.keys[i] < d.keys[j] })
+	return d
+}
+
+// Len returns the number of tiles in the set
+func (d *DoneTiles) Len() int {
+	return len(d.keys)
+}
+
+// Contains reports whether the tile is done
+func (d *DoneTiles) Contains(tc TileCoords) bool {
+	key := tileKey(tc)
+	i := sort.Search(len(d.keys), func(i int) bool { return d.keys[i] >= key })
+	return i < len(d.keys) && d.keys[i] == key
+}
+
+// SkipDone returns the tiles which aren't done yet, keeping their order
+func SkipDone(tiles []TileCoords, done *DoneTiles) []TileCoords {
+	out := make([]TileCoords, 0, len(tiles))
+	for _, tc := range tiles {
+		if !done.Contains(tc) {
+			out = append(out, tc)
+		}
+	}
+	return out
+}
+
+// insertJournaled inserts the tiles and records them in the journal in a single transaction,
+// so a tile is only marked done once it is committed
+func (w *MbTilesWriter) insertJournaled(data []mbtiles.TileData) error {
+	tx, err := w.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	tileStmt, err := tx.Prepare("INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer tileStmt.Close()
+	journalStmt, err := tx.Prepare("INSERT OR IGNORE INTO " + journalTable + " (z, x, y) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer journalStmt.Close()
+	for _, d := range data {
+		if _, err := tileStmt.Exec(d.Z, d.X, (1<<d.Z)-1-d.Y, d.Data); err != nil {
+			return err
+		}
+		if _, err := journalStmt.Exec(d.Z, d.X, d.Y); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ReadJournal returns the tiles recorded in the journal of a resumed mbtiles file
+func (w *MbTilesWriter) ReadJournal() (*DoneTiles, error) {
+	var name string
+	err := w.DB.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", journalTable).Scan(&name)
+	if err != nil {
+		return nil, fmt.Errorf("%s has no export journal to resume from, it was either completed or not written by an export: %w", w.Filename, err)
+	}
+	rows, err := w.DB.Query("SELECT z, x, y FROM " + journalTable + " ORDER BY z, x, y")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := &DoneTiles{}
+	for rows.Next() {
+		var tc TileCoords
+		if err := rows.Scan(&tc.Z, &tc.X, &tc.Y); err != nil {
+			return nil, err
+		}
+		done.keys = append(done.keys, tileKey(tc))
+	}
+	return done, rows.Err()
+}
+
+// MissingTiles returns the number of tiles which are neither in the journal nor skipped (if not nil)
+func MissingTiles(tiles []TileCoords, done *DoneTiles, skipped *SkippedTiles) int {
+	missing := 0
+	for _, tc := range tiles {
+		if !done.Contains(tc) && (skipped == nil || !skipped.Covers(tc)) {
+			missing++
+		}
+	}
+	return missing
+}
+
+// SaveState saves the extent and layers (if not nil) of the tiles in the journal, for LoadState
+func (w *MbTilesWriter) SaveState(extent *TileExtent, layers *LayerCollector) error {
+	if _, err := w.DB.Exec(createStateSQL); err != nil {
+		return err
+	}
+	tx, err := w.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	state := ExportState{Extent: extent, Layers: layers}
+	if err := tx.QueryRow("SELECT count(*) FROM " + journalTable).Scan(&state.Tiles); err != nil {
+		return err
+	}
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO "+stateTable+" (name, value) VALUES ('state', ?)", value); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadState reads the state saved by SaveState into extent and layers (if not nil). ok is false if there is
+// no saved state or it doesn't match the journal, eg: after a crash, and then extent and layers are left alone.
+func (w *MbTilesWriter) LoadState(extent *TileExtent, layers *LayerCollector) (ok bool, err error) {
+	var name string
+	err = w.DB.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", stateTable).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var value []byte
+	err = w.DB.QueryRow("SELECT value FROM " + stateTable + " WHERE name = 'state'").Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var saved struct {
+		Tiles int `json:"tiles"`
+	}
+	if err := json.Unmarshal(value, &saved); err != nil {
+		return false, err
+	}
+	var tiles int
+	if err := w.DB.QueryRow("SELECT count(*) FROM " + journalTable).Scan(&tiles); err != nil {
+		return false, err
+	}
+	if saved.Tiles != tiles {
+		return false, nil
+	}
+	if err := json.Unmarshal(value, &ExportState{Extent: extent, Layers: layers}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DropJournal removes the journal and the saved state once the export is complete
+func (w *MbTilesWriter) DropJournal() error {
+	if _, err := w.DB.Exec("DROP TABLE IF EXISTS " + journalTable); err != nil {
+		return err
+	}
+	_, err := w.DB.Exec("DROP TABLE IF EXISTS " + stateTable)
+	return err
+}
+
+// ScanEmptyTiles calls fn with every tile without features already in the mbtiles file.
+// Only the tiles small enough to be empty are read.
+func (w *MbTilesWriter) ScanEmptyTiles(fn func(tc TileCoords)) error {
+	rows, err := w.DB.Query("SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles WHERE length(tile_data) <= ?", maxEmptyGzipSize)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tc TileCoords
+		var row int
+		var data []byte
+		if err := rows.Scan(&tc.Z, &tc.X, &row, &data); err != nil {
+			return err
+		}
+		tc.Y = (1 << tc.Z) - 1 - row
+		if IsEmptyTile(data) {
+			fn(tc)
+		}
+	}
+	return rows.Err()
+}
+
+// ScanTiles calls fn with every tile already in the mbtiles file, eg: to rebuild stats when resuming
+func (w *MbTilesWriter) ScanTiles(fn func(tc TileCoords, data []byte) error) error {
+	rows, err := w.DB.Query("SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tc TileCoords
+		var row int
+		var data []byte
+		if err := rows.Scan(&tc.Z, &tc.X, &row, &data); err != nil {
+			return err
+		}
+		tc.Y = (1 << tc.Z) - 1 - row
+		if err := fn(tc, data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,137 @@
+package tileutils
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twpayne/go-mbtiles"
+)
+
+func TestDoneTiles(t *testing.T) {
+	done := NewDoneTiles([]TileCoords{{Z: 3, X: 1, Y: 2}, {Z: 0, X: 0, Y: 0}, {Z: 20, X: 1048575, Y: 1048575}})
+	assert.Equal(t, 3, done.Len())
+	assert.True(t, done.Contains(TileCoords{Z: 0, X: 0, Y: 0}))
+	assert.True(t, done.Contains(TileCoords{Z: 3, X: 1, Y: 2}))
+	assert.True(t, done.Contains(TileCoords{Z: 20, X: 1048575, Y: 1048575}))
+	assert.False(t, done.Contains(TileCoords{Z: 3, X: 2, Y: 1}))
+	assert.False(t, done.Contains(TileCoords{Z: 2, X: 1, Y: 2}))
+
+	tiles := []TileCoords{{Z: 3, X: 2, Y: 1}, {Z: 3, X: 1, Y: 2}, {Z: 1, X: 1, Y: 0}, {Z: 0, X: 0, Y: 0}}
+	assert.Equal(t, []TileCoords{{Z: 3, X: 2, Y: 1}, {Z: 1, X: 1, Y: 0}}, SkipDone(tiles, done))
+}
+
+func TestDoneTilesDeepZoom(t *testing.T) {
+	// column and row don't overlap at zoom 30, and the zooms don't either
+	a := TileCoords{Z: 30, X: 0, Y: 1 << 29}
+	b := TileCoords{Z: 30, X: 1, Y: 0}
+	last := TileCoords{Z: 30, X: 1<<30 - 1, Y: 1<<30 - 1}
+	first := TileCoords{Z: 31, X: 0, Y: 0}
+	done := NewDoneTiles([]TileCoords{a, last})
+	assert.True(t, done.Contains(a))
+	assert.False(t, done.Contains(b))
+	assert.True(t, done.Contains(last))
+	assert.False(t, done.Contains(first))
+	assert.Less(t, tileKey(a), tileKey(b))
+	assert.Less(t, tileKey(last), tileKey(first))
+	assert.Less(t, tileKey(TileCoords{Z: 29, X: 1<<29 - 1, Y: 1<<29 - 1}), tileKey(TileCoords{Z: 30}))
+}
+
+func TestJournalResume(t *testing.T) {
+	filename := path.Join(t.TempDir(), "out.mbtiles")
+	w := &MbTilesWriter{Filename: filename, Journal: true}
+	_, closeWriter, err := w.New()
+	require.Nil(t, err)
+	require.Nil(t, w.Write(2, 1, 0, []byte{1}))
+	require.Nil(t, w.BulkWrite([]mbtiles.TileData{{Z: 3, X: 4, Y: 5, Data: []byte{2}}, {Z: 0, X: 0, Y: 0, Data: []byte{3}}}))
+	closeWriter()
+
+	// resuming a missing file is an error rather than a new export
+	_, _, err = (&MbTilesWriter{Filename: path.Join(t.TempDir(), "missing.mbtiles"), Journal: true, Resume: true}).New()
+	assert.ErrorContains(t, err, "resume")
+
+	w = &MbTilesWriter{Filename: filename, Journal: true, Resume: true}
+	_, closeWriter, err = w.New()
+	require.Nil(t, err)
+	defer closeWriter()
+	done, err := w.ReadJournal()
+	require.Nil(t, err)
+	assert.Equal(t, 3, done.Len())
+	assert.True(t, done.Contains(TileCoords{Z: 3, X: 4, Y: 5}))
+	assert.True(t, done.Contains(TileCoords{Z: 2, X: 1, Y: 0}))
+
+	// the existing tiles are kept, with their xyz coordinates
+	tiles := map[TileCoords][]byte{}
+	require.Nil(t, w.ScanTiles(func(tc TileCoords, data []byte) error {
+		tiles[tc] = data
+		return nil
+	}))
+	assert.Equal(t, map[TileCoords][]byte{
+		{Z: 2, X: 1, Y: 0}: {1},
+		{Z: 3, X: 4, Y: 5}: {2},
+		{Z: 0, X: 0, Y: 0}: {3},
+	}, tiles)
+
+	require.Nil(t, w.DropJournal())
+	_, err = w.ReadJournal()
+	assert.ErrorContains(t, err, "no export journal")
+}
+
+func TestExportState(t *testing.T) {
+	filename := path.Join(t.TempDir(), "out.mbtiles")
+	w := &MbTilesWriter{Filename: filename, Journal: true}
+	_, closeWriter, err := w.New()
+	require.Nil(t, err)
+	empty, err := Gzip([]byte{})
+	require.Nil(t, err)
+	data, err := os.ReadFile("testdata/5-7-12.mvt")
+	require.Nil(t, err)
+	compressed, err := Gzip(data)
+	require.Nil(t, err)
+	extent := NewTileExtent()
+	layers := NewLayerCollector(DefaultTileStatsValues)
+	ew := &ExtentWriter{Writer: w, BulkWriter: w, Extent: extent}
+	require.Nil(t, ew.BulkWrite([]mbtiles.TileData{{Z: 5, X: 7, Y: 12, Data: compressed}, {Z: 5, X: 8, Y: 12, Data: empty}}))
+	require.Nil(t, layers.Add(data))
+	require.Nil(t, w.SaveState(extent, layers))
+	closeWriter()
+
+	w = &MbTilesWriter{Filename: filename, Journal: true, Resume: true}
+	_, closeWriter, err = w.New()
+	require.Nil(t, err)
+	defer closeWriter()
+	resumedExtent := NewTileExtent()
+	resumedLayers := NewLayerCollector(DefaultTileStatsValues)
+	ok, err := w.LoadState(resumedExtent, resumedLayers)
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, extent.Counts(), resumedExtent.Counts())
+	assert.Equal(t, extent.Bounds(), resumedExtent.Bounds())
+	assert.Equal(t, layers.Fields(), resumedLayers.Fields())
+	assert.Equal(t, layers.TileStats(), resumedLayers.TileStats())
+
+	var emptyTiles []TileCoords
+	require.Nil(t, w.ScanEmptyTiles(func(tc TileCoords) { emptyTiles = append(emptyTiles, tc) }))
+	assert.Equal(t, []TileCoords{{Z: 5, X: 8, Y: 12}}, emptyTiles)
+
+	// tiles committed after the state was saved make it stale
+	require.Nil(t, w.Write(5, 9, 12, compressed))
+	ok, err = w.LoadState(NewTileExtent(), NewLayerCollector(DefaultTileStatsValues))
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	done, err := w.ReadJournal()
+	require.Nil(t, err)
+	tiles := []TileCoords{{Z: 5, X: 7, Y: 12}, {Z: 5, X: 9, Y: 12}, {Z: 6, X: 0, Y: 0}, {Z: 6, X: 1, Y: 1}}
+	assert.Equal(t, 2, MissingTiles(tiles, done, nil))
+	skipped := NewSkippedTiles()
+	skipped.Add([]SkippedSubtree{{Root: TileCoords{Z: 6, X: 0, Y: 0}, Zoom: 6}})
+	assert.Equal(t, 1, MissingTiles(tiles, done, skipped))
+
+	require.Nil(t, w.DropJournal())
+	ok, err = w.LoadState(NewTileExtent(), NewLayerCollector(DefaultTileStatsValues))
+	require.Nil(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,228 @@
+package tileutils
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/paulmach/orb/encoding/mvt/vectortile"
+)
+
+// Field types used by the vector_layers fields in the mbtiles metadata
+const (
+	FieldTypeString  = "String"
+	FieldTypeNumber  = "Number"
+	FieldTypeBoolean = "Boolean"
+)
+
+// LayerCollector gathers the attributes and stats of each vector layer from the tiles as they are produced,
+// so they can be listed in the metadata. It is safe for concurrent use.
+type LayerCollector struct {
+	mu         sync.Mutex
+	fields     map[string]map[string]string
+	stats      map[string]*layerStats
+	sampleSize int
+}
+
+// NewLayerCollector creates an empty LayerCollector which keeps up to sampleSize values of each attribute for the tilestats
+func NewLayerCollector(sampleSize int) *LayerCollector {
+	return &LayerCollector{
+		fields:     map[string]map[string]string{},
+		stats:      map[string]*layerStats{},
+		sampleSize: sampleSize,
+	}
+}
+
+// valueType returns the vector_layers field type of a tile value
+func valueType(v *vectortile.Tile_Value) string {
+	switch {
+	case v.StringValue != nil:
+		return FieldTypeString
+	case v.BoolValue != nil:
+		return FieldTypeBoolean
+	case v.FloatValue != nil, v.DoubleValue != nil, v.IntValue != nil, v.UintValue != nil, v.SintValue != nil:
+		return FieldTypeNumber
+	}
+	return ""
+}
+
+// Add decodes an uncompressed MVT tile and records the attribute names and types used by the features of each layer.
+// An attribute whose type varies between features is recorded as a String, as the mbtiles spec asks.
+func (c *LayerCollector) Add(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	tile := vectortile.Tile{}
+	if err := tile.Unmarshal(data); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, layer := range tile.GetLayers() {
+		fields, ok := c.fields[layer.GetName()]
+		if !ok {
+			fields = map[string]string{}
+			c.fields[layer.GetName()] = fields
+		}
+		stats, ok := c.stats[layer.GetName()]
+		if !ok {
+			stats = newLayerStats()
+			c.stats[layer.GetName()] = stats
+		}
+		keys := layer.GetKeys()
+		values := layer.GetValues()
+		for _, feature := range layer.GetFeatures() {
+			stats.count++
+			stats.geometries[feature.GetType()]++
+			tags := feature.GetTags()
+			for i := 0; i+1 < len(tags); i += 2 {
+				if int(tags[i]) >= len(keys) || int(tags[i+1]) >= len(values) {
+					continue
+				}
+				key := keys[tags[i]]
+				value := values[tags[i+1]]
+				if attr := stats.attribute(key); attr != nil {
+					attr.add(value, c.sampleSize)
+				}
+				fieldType := valueType(value)
+				if existing, ok := fields[key]; ok && existing != fieldType {
+					fieldType = FieldTypeString
+				}
+				fields[key] = fieldType
+			}
+		}
+	}
+	return nil
+}
+
+// layerCollectorJSON is a LayerCollector as saved with the export journal
+type layerCollectorJSON struct {
+	Fields map[string]map[string]string `json:"fields"`
+	Stats  map[string]layerStatsJSON    `json:"stats"`
+}
+
+// MarshalJSON saves the fields and stats collected so far, so a resumed export can start from them
+func (c *LayerCollector) MarshalJSON() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := layerCollectorJSON{Fields: c.fields, Stats: make(map[string]layerStatsJSON, len(c.stats))}
+	for name, stats := range c.stats {
+		out.Stats[name] = stats.toJSON()
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON replaces the fields and stats with the ones saved by MarshalJSON, keeping the sample size
+func (c *LayerCollector) UnmarshalJSON(data []byte) error {
+	var in layerCollectorJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	fields := in.Fields
+	if fields == nil {
+		fields = map[string]map[string]string{}
+	}
+	stats := make(map[string]*layerStats, len(in.Stats))
+	for name, s := range in.Stats {
+		stats[name] = s.layerStats()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fields = fields
+	c.stats = stats
+	return nil
+}
+
+// Fields returns a copy of the attribute names and types found in each layer
+func (c *LayerCollector) Fields() map[string]map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]map[string]string, len(c.fields))
+	for layer, fields := range c.fields {
+		out[layer] = make(map[string]string, len(fields))
+		for k, v := range fields {
+			out[layer][k] = v
+		}
+	}
+	return out
+}
+
+// TileStats returns the tilestats of the layers seen so far
+func (c *LayerCollector) TileStats() *TileStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := &TileStats{
+		LayerCount: len(c.stats),
+		Layers:     make([]TileStatsLayer, 0, len(c.stats)),
+	}
+	for name, stats := range c.stats {
+		out.Layers = append(out.Layers, stats.tileStats(name))
+	}
+	sort.Slice(out.Layers, func(i, j int) bool { return out.Layers[i].Layer < out.Layers[j].Layer })
+	return out
+}
+
+// errBadTile is returned when a tile isn't a valid protobuf message
+var errBadTile = errors.New("invalid mvt tile")
+
+// nextField reads the first protobuf field of data. value is the payload of length-delimited fields.
+func nextField(data []byte) (field uint64, value, rest []byte, err error) {
+	key, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, nil, errBadTile
+	}
+	data = data[n:]
+	switch key & 7 {
+	case 0: // varint
+		if _, n = binary.Uvarint(data); n <= 0 {
+			return 0, nil, nil, errBadTile
+		}
+	case 1: // 64-bit
+		n = 8
+	case 2: // length-delimited
+		length, m := binary.Uvarint(data)
+		if m <= 0 || length > uint64(len(data)-m) {
+			return 0, nil, nil, errBadTile
+		}
+		value = data[m : m+int(length)]
+		n = m + int(length)
+	case 5: // 32-bit
+		n = 4
+	default:
+		return 0, nil, nil, errBadTile
+	}
+	if n > len(data) {
+		return 0, nil, nil, errBadTile
+	}
+	return key >> 3, value, data[n:], nil
+}
+
+// LayerSizes returns the encoded bytes of each layer of an uncompressed MVT tile, without decoding the features
+func LayerSizes(data []byte) (map[string]int, error) {
+	sizes := map[string]int{}
+	for len(data) > 0 {
+		field, layer, rest, err := nextField(data)
+		if err != nil {
+			return nil, err
+		}
+		if field == 3 { // Tile.layers
+			name := ""
+			for msg := layer; len(msg) > 0; {
+				f, value, r, err := nextField(msg)
+				if err != nil {
+					return nil, err
+				}
+				if f == 1 { // Layer.name
+					name = string(value)
+					break
+				}
+				msg = r
+			}
+			sizes[name] += len(data) - len(rest)
+		}
+		data = rest
+	}
+	return sizes, nil
+}
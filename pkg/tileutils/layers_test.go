@@ -0,0 +1,67 @@
+package tileutils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayerCollector(t *testing.T) {
+	c := NewLayerCollector(DefaultTileStatsValues)
+	data, err := os.ReadFile("testdata/5-7-12.mvt")
+	require.Nil(t, err)
+	require.Nil(t, c.Add(data))
+	fields := c.Fields()
+	require.Contains(t, fields, "ne_10m_roads")
+	assert.Equal(t, FieldTypeString, fields["ne_10m_roads"]["name"])
+	assert.Equal(t, FieldTypeNumber, fields["ne_10m_roads"]["min_zoom"])
+
+	// a field with a different type in another tile becomes a string
+	fc := geojson.NewFeatureCollection()
+	f := geojson.NewFeature(orb.Point{1, 1})
+	f.Properties["min_zoom"] = "low"
+	f.Properties["oneway"] = true
+	fc.Append(f)
+	tile, err := mvt.Marshal(mvt.NewLayers(map[string]*geojson.FeatureCollection{"ne_10m_roads": fc}))
+	require.Nil(t, err)
+	require.Nil(t, c.Add(tile))
+	fields = c.Fields()
+	assert.Equal(t, FieldTypeString, fields["ne_10m_roads"]["min_zoom"])
+	assert.Equal(t, FieldTypeBoolean, fields["ne_10m_roads"]["oneway"])
+
+	assert.Nil(t, c.Add(nil))
+	assert.NotNil(t, c.Add([]byte{0xff, 0xff, 0xff}))
+}
+
+func TestCreateMetadataJSONFields(t *testing.T) {
+	tj := &TileJSON{VectorLayers: []VectorLayer{{ID: "roads"}, {ID: "lakes"}}}
+	meta := CreateMetadataJSON(tj, CreateMetadataOptions{Fields: map[string]map[string]string{"roads": {"name": FieldTypeString}}})
+	require.Len(t, meta.VectorLayers, 2)
+	assert.Equal(t, map[string]string{"name": FieldTypeString}, meta.VectorLayers[0].Fields)
+	assert.Equal(t, map[string]string{}, meta.VectorLayers[1].Fields)
+	assert.Nil(t, meta.TileStats)
+}
+
+func TestLayerSizes(t *testing.T) {
+	fc := geojson.NewFeatureCollection()
+	fc.Append(geojson.NewFeature(orb.Point{1, 1}))
+	roads, err := mvt.Marshal(mvt.NewLayers(map[string]*geojson.FeatureCollection{"roads": fc}))
+	require.Nil(t, err)
+	water, err := mvt.Marshal(mvt.NewLayers(map[string]*geojson.FeatureCollection{"water": fc}))
+	require.Nil(t, err)
+	// the combined query concatenates the layers
+	sizes, err := LayerSizes(append(append([]byte{}, roads...), water...))
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int{"roads": len(roads), "water": len(water)}, sizes)
+
+	sizes, err = LayerSizes(nil)
+	require.Nil(t, err)
+	assert.Empty(t, sizes)
+	_, err = LayerSizes([]byte{0x1a, 0x05, 0x0a})
+	assert.NotNil(t, err)
+}
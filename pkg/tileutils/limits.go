@@ -0,0 +1,117 @@
+package tileutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ZoomConcurrency limits how many tiles within a range of zooms are rendered at once.
+// MaxZoom is inclusive.
+type ZoomConcurrency struct {
+	MinZoom int `json:"minzoom"`
+	MaxZoom int `json:"maxzoom"`
+	Workers int `json:"workers"`
+}
+
+// ParseZoomConcurrency parses a comma-delimited list of zoom limits, where each entry is
+// minzoom-maxzoom:workers or zoom:workers (eg: 0-6:4,7-10:16,14:96)
+func ParseZoomConcurrency(s string) ([]ZoomConcurrency, error) {
+	limits := []ZoomConcurrency{}
+	for _, entry := range strings.Split(s, ",") {
+		zoomRange, workers, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid zoom concurrency (%s), expected minzoom-maxzoom:workers", entry)
+		}
+		var limit ZoomConcurrency
+		var err error
+		minZoom, maxZoom, isRange := strings.Cut(zoomRange, "-")
+		if limit.MinZoom, err = strconv.Atoi(minZoom); err != nil {
+			return nil, fmt.Errorf("invalid zoom concurrency (%s): %w", entry, err)
+		}
+		limit.MaxZoom = limit.MinZoom
+		if isRange {
+			if limit.MaxZoom, err = strconv.Atoi(maxZoom); err != nil {
+				return nil, fmt.Errorf("invalid zoom concurrency (%s): %w", entry, err)
+			}
+		}
+		if limit.Workers, err = strconv.Atoi(workers); err != nil {
+			return nil, fmt.Errorf("invalid zoom concurrency (%s): %w", entry, err)
+		}
+		limits = append(limits, limit)
+	}
+	return limits, ValidateZoomConcurrency(limits)
+}
+
+// ValidateZoomConcurrency checks that every limit has a valid zoom range and allows at least one worker
+func ValidateZoomConcurrency(limits []ZoomConcurrency) error {
+	for _, limit := range limits {
+		if limit.MaxZoom < limit.MinZoom {
+			return fmt.Errorf("invalid zoom concurrency for zooms %d-%d, maxzoom is below minzoom", limit.MinZoom, limit.MaxZoom)
+		}
+		if limit.Workers < 1 {
+			return fmt.Errorf("invalid zoom concurrency for zooms %d-%d, workers must be at least 1", limit.MinZoom, limit.MaxZoom)
+		}
+	}
+	return nil
+}
+
+// ZoomLimit returns the number of tiles at zoom z that can be rendered at once, capped at workers
+func ZoomLimit(limits []ZoomConcurrency, z int, workers int) int {
+	limit := workers
+	for _, l := range limits {
+		if z >= l.MinZoom && z <= l.MaxZoom && l.Workers < limit {
+			limit = l.Workers
+		}
+	}
+	return limit
+}
+
+// MaxConcurrency returns the most tiles that can be rendered at once for any of the zooms, capped at workers
+func MaxConcurrency(limits []ZoomConcurrency, zooms []int, workers int) int {
+	max := 0
+	for _, z := range zooms {
+		if limit := ZoomLimit(limits, z, workers); limit > max {
+			max = limit
+		}
+	}
+	return max
+}
+
+// ZoomLimiter enforces zoom concurrency limits with a semaphore for each range.
+// It is safe for concurrent use.
+type ZoomLimiter struct {
+	limits []ZoomConcurrency
+	sems   []chan struct{}
+}
+
+// NewZoomLimiter creates a ZoomLimiter for the limits
+func NewZoomLimiter(limits []ZoomConcurrency) *ZoomLimiter {
+	l := &ZoomLimiter{
+		limits: limits,
+		sems:   make([]chan struct{}, len(limits)),
+	}
+	for i, limit := range limits {
+		l.sems[i] = make(chan struct{}, limit.Workers)
+	}
+	return l
+}
+
+// Acquire blocks until a tile at zoom z may be rendered. Overlapping ranges are always
+// acquired in the same order so workers can't deadlock each other.
+func (l *ZoomLimiter) Acquire(z int) {
+	for i, limit := range l.limits {
+		if z >= limit.MinZoom && z <= limit.MaxZoom {
+			l.sems[i] <- struct{}{}
+		}
+	}
+}
+
+// Release frees the slot taken by Acquire for a tile at zoom z
+func (l *ZoomLimiter) Release(z int) {
+	for i, limit := range l.limits {
+		if z >= limit.MinZoom && z <= limit.MaxZoom {
+			<-l.sems[i]
+		}
+	}
+}
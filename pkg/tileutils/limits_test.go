@@ -0,0 +1,72 @@
+package tileutils
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseZoomConcurrency(t *testing.T) {
+	limits, err := ParseZoomConcurrency("0-6:4,7-10:16,14:96")
+	require.Nil(t, err)
+	assert.Equal(t, []ZoomConcurrency{
+		{MinZoom: 0, MaxZoom: 6, Workers: 4},
+		{MinZoom: 7, MaxZoom: 10, Workers: 16},
+		{MinZoom: 14, MaxZoom: 14, Workers: 96},
+	}, limits)
+
+	for _, s := range []string{"0-6", "6-0:4", "0-6:0", "a:4", "0-b:4", "1:c"} {
+		_, err := ParseZoomConcurrency(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestZoomLimit(t *testing.T) {
+	limits := []ZoomConcurrency{
+		{MinZoom: 0, MaxZoom: 6, Workers: 4},
+		{MinZoom: 5, MaxZoom: 10, Workers: 16},
+		{MinZoom: 14, MaxZoom: 14, Workers: 96},
+	}
+	assert.Equal(t, 4, ZoomLimit(limits, 2, 48))
+	assert.Equal(t, 4, ZoomLimit(limits, 5, 48))
+	assert.Equal(t, 16, ZoomLimit(limits, 8, 48))
+	assert.Equal(t, 48, ZoomLimit(limits, 12, 48))
+	assert.Equal(t, 48, ZoomLimit(limits, 14, 48))
+
+	assert.Equal(t, 16, MaxConcurrency(limits, []int{0, 1, 2, 3, 4, 5, 6, 7, 8}, 48))
+	assert.Equal(t, 48, MaxConcurrency(limits, []int{0, 14}, 48))
+}
+
+func TestZoomLimiter(t *testing.T) {
+	limiter := NewZoomLimiter([]ZoomConcurrency{{MinZoom: 0, MaxZoom: 6, Workers: 2}})
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Acquire(3)
+			defer limiter.Release(3)
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), maxRunning)
+
+	// zooms outside of any range are not limited
+	for i := 0; i < 4; i++ {
+		limiter.Acquire(12)
+	}
+}
@@ -0,0 +1,35 @@
+package tileutils
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Log formats supported by NewLogger
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// NewLogger creates a logger writing to w at level (debug, info, warn or error),
+// in the text or json format
+func NewLogger(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level (%s), expected debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format (%s), expected %s or %s", format, LogFormatText, LogFormatJSON)
+}
+
+// TileAttr returns the z, x and y fields of a tile for a log record
+func TileAttr(tc TileCoords) slog.Attr {
+	return slog.Group("", "z", tc.Z, "x", tc.X, "y", tc.Y)
+}
@@ -0,0 +1,36 @@
+package tileutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := NewLogger(buf, "info", LogFormatJSON)
+	require.Nil(t, err)
+	logger.Debug("hidden")
+	logger.Info("tile written", TileAttr(TileCoords{Z: 5, X: 7, Y: 12}), "layer", "roads")
+	var record map[string]interface{}
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "tile written", record["msg"])
+	assert.Equal(t, 5.0, record["z"])
+	assert.Equal(t, 7.0, record["x"])
+	assert.Equal(t, 12.0, record["y"])
+	assert.Equal(t, "roads", record["layer"])
+
+	buf.Reset()
+	logger, err = NewLogger(buf, "debug", LogFormatText)
+	require.Nil(t, err)
+	logger.Debug("listing tiles", "zoom", 3)
+	assert.Contains(t, buf.String(), "level=DEBUG msg=\"listing tiles\" zoom=3")
+
+	_, err = NewLogger(buf, "loud", LogFormatText)
+	assert.NotNil(t, err)
+	_, err = NewLogger(buf, "info", "xml")
+	assert.NotNil(t, err)
+}
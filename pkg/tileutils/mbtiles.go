@@ -21,24 +21,100 @@ const (
 )
 
 type CreateMetadataOptions struct {
-	Filename string
-	Version  string
-	Format   MbTilesFormat
+	Filename  string
+	Version   string
+	Format    MbTilesFormat
+	Fields    map[string]map[string]string // attribute names and types of each layer, see LayerCollector
+	TileStats *TileStats                   // stats of the layers, added to the json field if not nil
+	Overrides map[string]string            // metadata values that replace the generated ones, eg: type=overlay
+}
+
+// tileJSONOnlyKeys are TileJSON keys about serving tiles, which don't belong in the mbtiles metadata
+var tileJSONOnlyKeys = map[string]bool{
+	"tilejson": true,
+	"tiles":    true,
+	"grids":    true,
+	"data":     true,
+	"scheme":   true,
+}
+
+// metadataValue converts a raw json value to a metadata value: strings are unquoted, anything else is kept as json
+func metadataValue(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// MetadataJSON is the json field of the metadata: the vector layers and optionally the tilestats
+type MetadataJSON struct {
+	VectorLayers []MetadataVectorLayer `json:"vector_layers"`
+	TileStats    *TileStats            `json:"tilestats,omitempty"`
+}
+
+// MetadataVectorLayer is a vector layer of the metadata json field, and of TileJSON documents
+type MetadataVectorLayer struct {
+	mbtiles.MetadataJsonVectorLayer
+
+	Extra map[string]json.RawMessage `json:"-"` // other keys of the input layer, written unless the layer sets them
+}
+
+// MarshalJSON writes the layer fields along with the extra keys
+func (l MetadataVectorLayer) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(l.MetadataJsonVectorLayer)
+	if err != nil {
+		return nil, err
+	}
+	return addExtraFields(data, l.Extra)
+}
+
+// UnmarshalJSON reads the layer fields, and keeps the other keys as extra keys
+func (l *MetadataVectorLayer) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &l.MetadataJsonVectorLayer); err != nil {
+		return err
+	}
+	var err error
+	l.Extra, err = unknownFields(data, l.MetadataJsonVectorLayer)
+	return err
+}
+
+// addExtraFields adds the extra keys to a json object, unless it already has them
+func addExtraFields(data []byte, extra map[string]json.RawMessage) ([]byte, error) {
+	if len(extra) == 0 {
+		return data, nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range extra {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	return json.Marshal(fields)
 }
 
 // CreateMetadata generates the (name,value) metadata pairs for .mbtiles files.
 // Since name is required, it falls back to the filename if not provided.
 // format is also required, so it falls back to pbf if not provided.
+// Keys of the TileJSON that aren't modeled are copied over, and opts.Overrides replace any generated value.
 func CreateMetadata(tj *TileJSON, opts CreateMetadataOptions) MbTilesMetadata {
 	format := opts.Format
 	if string(format) == "" {
 		format = MbTilesFormatPbf
 	}
 	meta := MbTilesMetadata{
-		"name":   tj.Name,
-		"format": string(format),
-		"type":   "baselayer",
+		"type": "baselayer",
+	}
+	for k, v := range tj.Extra {
+		if !tileJSONOnlyKeys[k] {
+			meta[k] = metadataValue(v)
+		}
 	}
+	meta["name"] = tj.Name
+	meta["format"] = string(format)
 	if tj.Name == "" && opts.Filename != "" {
 		meta["name"] = opts.Filename
 	}
@@ -61,8 +137,8 @@ func CreateMetadata(tj *TileJSON, opts CreateMetadataOptions) MbTilesMetadata {
 	if tj.MaxZoom != -1 {
 		meta["maxzoom"] = strconv.Itoa(tj.MaxZoom)
 	}
-	if tj.Bounds != nil {
-		meta["bounds"] = strings.Join(floatToString(tj.Bounds), ",")
+	if bounds := tj.UnionBounds(); bounds != nil {
+		meta["bounds"] = strings.Join(floatToString(bounds), ",")
 	}
 	if tj.Center != nil {
 		center := ""
@@ -77,30 +153,44 @@ func CreateMetadata(tj *TileJSON, opts CreateMetadataOptions) MbTilesMetadata {
 
 	// mbtiles spec requires the json field for vector format and it's not meaningful for rasters
 	if opts.Format == MbTilesFormatPbf {
-		metaJSONField := CreateMetadataJSON(tj)
+		metaJSONField := CreateMetadataJSON(tj, opts)
 		if metaJSONBytes, err := json.Marshal(metaJSONField); err == nil {
 			meta["json"] = string(metaJSONBytes)
 		}
 	}
+	for k, v := range opts.Overrides {
+		meta[k] = v
+	}
 
 	return meta
 }
 
-// CreateMetadataJSON generates a mbtiles MetadataJson object based on the TileJSON input
-func CreateMetadataJSON(tj *TileJSON) *mbtiles.MetadataJson {
-	meta := mbtiles.MetadataJson{
-		VectorLayers: extractLayersFromTileJSON(tj),
+// CreateMetadataJSON generates the json metadata object based on the TileJSON input,
+// with the layer fields and tilestats from opts when they are set
+func CreateMetadataJSON(tj *TileJSON, opts CreateMetadataOptions) *MetadataJSON {
+	meta := MetadataJSON{
+		VectorLayers: extractLayersFromTileJSON(tj, opts.Fields),
+		TileStats:    opts.TileStats,
 	}
 	return &meta
 }
 
-func extractLayersFromTileJSON(tj *TileJSON) []mbtiles.MetadataJsonVectorLayer {
-	layers := make([]mbtiles.MetadataJsonVectorLayer, 0, len(tj.VectorLayers))
+func extractLayersFromTileJSON(tj *TileJSON, fields map[string]map[string]string) []MetadataVectorLayer {
+	layers := make([]MetadataVectorLayer, 0, len(tj.VectorLayers))
 	for _, layer := range tj.VectorLayers {
 		l := layer // create local variable copy
-		layer := mbtiles.MetadataJsonVectorLayer{
-			ID:     &l.ID,
-			Fields: map[string]string{},
+		layer := MetadataVectorLayer{
+			MetadataJsonVectorLayer: mbtiles.MetadataJsonVectorLayer{
+				ID:     &l.ID,
+				Fields: map[string]string{},
+			},
+			Extra: l.Extra,
+		}
+		if l.Description != "" {
+			layer.Description = &l.Description
+		}
+		for name, fieldType := range fields[l.ID] {
+			layer.Fields[name] = fieldType
 		}
 		minzoom := -1
 		maxzoom := -1
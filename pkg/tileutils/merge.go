@@ -0,0 +1,398 @@
+package tileutils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // register the sqlite3 driver
+)
+
+// mergeSampleSize is the number of duplicate or missing tiles kept as examples in a MergeResult
+const mergeSampleSize = 10
+
+// MergeResult summarizes the merge of shard mbtiles files
+type MergeResult struct {
+	Tiles          int          // number of tiles in the merged output
+	Duplicates     int          // number of tiles found in more than one input
+	DuplicateTiles []TileCoords // a sample of the duplicate tiles
+	Missing        int          // number of expected tiles that are not in any input
+	MissingTiles   []TileCoords // a sample of the missing tiles
+}
+
+// ReadMbTilesMetadata reads all of the metadata rows from a mbtiles file
+func ReadMbTilesMetadata(filename string) (MbTilesMetadata, error) {
+	db, err := sql.Open("sqlite3", "file:"+filename+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT name, value FROM metadata")
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata from %s: %w", filename, err)
+	}
+	defer rows.Close()
+	meta := MbTilesMetadata{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		meta[name] = value
+	}
+	return meta, rows.Err()
+}
+
+// CheckShards checks that the inputs are the complete set of shards of one export:
+// every input has shard metadata with the same count, and every shard appears exactly once
+func CheckShards(inputs []string, metas []MbTilesMetadata) error {
+	count := 0
+	seen := map[int]string{}
+	for i, meta := range metas {
+		shard, err := ParseShard(meta["shard"])
+		if err != nil {
+			return fmt.Errorf("%s is not a shard export: %w", inputs[i], err)
+		}
+		if count == 0 {
+			count = shard.Count
+		} else if shard.Count != count {
+			return fmt.Errorf("%s is shard %s, but other inputs are split into %d shards", inputs[i], shard, count)
+		}
+		if other, ok := seen[shard.Index]; ok {
+			return fmt.Errorf("%s and %s are both shard %s", other, inputs[i], shard)
+		}
+		seen[shard.Index] = inputs[i]
+	}
+	missing := []string{}
+	for i := 1; i <= count; i++ {
+		if _, ok := seen[i]; !ok {
+			missing = append(missing, Shard{Index: i, Count: count}.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing shards: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// MergeMetadata combines the metadata of several shards. Values are taken from the first shard,
+// except minzoom, maxzoom and bounds which cover all shards, and the vector layers and tilestats in the json
+// field which are unioned by id, keeping up to statsValues sample values per attribute. The shard key is removed.
+func MergeMetadata(metas []MbTilesMetadata, statsValues int) MbTilesMetadata {
+	merged := MbTilesMetadata{}
+	if len(metas) == 0 {
+		return merged
+	}
+	for k, v := range metas[0] {
+		merged[k] = v
+	}
+	delete(merged, "shard")
+
+	minZoom, maxZoom := -1, -1
+	var bounds []float64
+	layers := []MetadataVectorLayer{}
+	layerIndex := map[string]int{}
+	stats := []*TileStats{}
+	for _, meta := range metas {
+		if z, err := strconv.Atoi(meta["minzoom"]); err == nil && (minZoom == -1 || z < minZoom) {
+			minZoom = z
+		}
+		if z, err := strconv.Atoi(meta["maxzoom"]); err == nil && z > maxZoom {
+			maxZoom = z
+		}
+		if b, err := parseFloats(meta["bounds"]); err == nil && len(b) == 4 {
+			if bounds == nil {
+				bounds = b
+			} else {
+				bounds = []float64{
+					math.Min(bounds[0], b[0]),
+					math.Min(bounds[1], b[1]),
+					math.Max(bounds[2], b[2]),
+					math.Max(bounds[3], b[3]),
+				}
+			}
+		}
+		var metaJSON MetadataJSON
+		if err := json.Unmarshal([]byte(meta["json"]), &metaJSON); err != nil {
+			continue
+		}
+		stats = append(stats, metaJSON.TileStats)
+		for _, layer := range metaJSON.VectorLayers {
+			if layer.ID == nil {
+				continue
+			}
+			i, ok := layerIndex[*layer.ID]
+			if !ok {
+				layerIndex[*layer.ID] = len(layers)
+				if layer.Fields == nil {
+					layer.Fields = map[string]string{}
+				}
+				layers = append(layers, layer)
+				continue
+			}
+			mergeVectorLayer(&layers[i], layer)
+		}
+	}
+	if minZoom != -1 {
+		merged["minzoom"] = strconv.Itoa(minZoom)
+	}
+	if maxZoom != -1 {
+		merged["maxzoom"] = strconv.Itoa(maxZoom)
+	}
+	if bounds != nil {
+		merged["bounds"] = strings.Join(floatToString(bounds), ",")
+	}
+	if _, ok := merged["json"]; ok {
+		if metaJSONBytes, err := json.Marshal(MetadataJSON{
+			VectorLayers: layers,
+			TileStats:    MergeTileStats(stats, statsValues),
+		}); err == nil {
+			merged["json"] = string(metaJSONBytes)
+		}
+	}
+	return merged
+}
+
+// mergeVectorLayer widens the zoom range of a vector layer and adds any new fields and extra keys from other
+func mergeVectorLayer(layer *MetadataVectorLayer, other MetadataVectorLayer) {
+	if other.MinZoom != nil && (layer.MinZoom == nil || *other.MinZoom < *layer.MinZoom) {
+		layer.MinZoom = other.MinZoom
+	}
+	if other.MaxZoom != nil && (layer.MaxZoom == nil || *other.MaxZoom > *layer.MaxZoom) {
+		layer.MaxZoom = other.MaxZoom
+	}
+	if layer.Description == nil {
+		layer.Description = other.Description
+	}
+	for name, fieldType := range other.Fields {
+		if existing, ok := layer.Fields[name]; ok && existing != fieldType {
+			// the spec asks for String when a field's type varies
+			layer.Fields[name] = "String"
+			continue
+		}
+		layer.Fields[name] = fieldType
+	}
+	for k, v := range other.Extra {
+		if _, ok := layer.Extra[k]; ok {
+			continue
+		}
+		if layer.Extra == nil {
+			layer.Extra = map[string]json.RawMessage{}
+		}
+		layer.Extra[k] = v
+	}
+}
+
+func parseFloats(s string) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	out := make([]float64, len(parts))
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = f
+	}
+	return out, nil
+}
+
+// MergeOptions configures MergeMbTiles
+type MergeOptions struct {
+	Expected        []TileCoords // tiles the export should have, checked for missing tiles if not nil
+	TileStatsValues int          // sample values kept for each attribute in the merged tilestats
+	Unfinished      bool         // merge shards whose export was interrupted or had failed tiles
+}
+
+// checkFinished returns an error if the mbtiles still has the journal of an export, which is only
+// dropped once the export has every tile
+func checkFinished(filename string) error {
+	db, err := sql.Open("sqlite3", "file:"+filename+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN (?, ?)", journalTable, stateTable).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", filename, err)
+	}
+	if count > 0 {
+		return fmt.Errorf("%s is an unfinished export, resume it with --resume before merging", filename)
+	}
+	return nil
+}
+
+// MergeMbTiles merges the shard mbtiles inputs into a new output file. The inputs must be the complete set
+// of shards of an export (see CheckShards), and finished unless opts.Unfinished is set. Tiles found in more than one input are counted as duplicates and
+// only the first copy is kept. If opts.Expected is not nil, the merged tiles are also checked for missing tiles.
+func MergeMbTiles(output string, inputs []string, opts MergeOptions) (*MergeResult, error) {
+	metas := make([]MbTilesMetadata, len(inputs))
+	for i, input := range inputs {
+		meta, err := ReadMbTilesMetadata(input)
+		if err != nil {
+			return nil, err
+		}
+		metas[i] = meta
+		if !opts.Unfinished {
+			if err := checkFinished(input); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := CheckShards(inputs, metas); err != nil {
+		return nil, err
+	}
+
+	w := &MbTilesWriter{Filename: output}
+	_, closeWriter, err := w.New()
+	if err != nil {
+		return nil, err
+	}
+	defer closeWriter()
+
+	// attached databases only exist on one connection, so hold on to a single one
+	ctx := context.Background()
+	conn, err := w.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result := &MergeResult{}
+	skipped := NewSkippedTiles()
+	for _, input := range inputs {
+		if err := mergeShard(ctx, conn, input, skipped, result); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM tiles").Scan(&result.Tiles); err != nil {
+		return nil, err
+	}
+	if opts.Expected != nil {
+		if err := findMissingTiles(ctx, conn, opts.Expected, skipped, result); err != nil {
+			return nil, err
+		}
+	}
+	if skipped.Len() > 0 {
+		if err := w.RecordSkipped(skipped.Subtrees()); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.BulkWriteMetadata(MergeMetadata(metas, opts.TileStatsValues)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// mergeShard copies the tiles of a single input into the output, recording duplicates in the result,
+// and adds the tiles the shard skipped on purpose to skipped
+func mergeShard(ctx context.Context, conn *sql.Conn, input string, skipped *SkippedTiles, result *MergeResult) error {
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS shard", input); err != nil {
+		return fmt.Errorf("error opening %s: %w", input, err)
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE shard")
+	if err := readSkipped(ctx, conn, skipped); err != nil {
+		return fmt.Errorf("error reading skipped tiles from %s: %w", input, err)
+	}
+
+	var count, lastRowID int64
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM shard.tiles").Scan(&count); err != nil {
+		return fmt.Errorf("error reading tiles from %s: %w", input, err)
+	}
+	if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(rowid), 0) FROM main.tiles").Scan(&lastRowID); err != nil {
+		return err
+	}
+	res, err := conn.ExecContext(ctx, "INSERT OR IGNORE INTO main.tiles (zoom_level, tile_column, tile_row, tile_data) "+
+		"SELECT zoom_level, tile_column, tile_row, tile_data FROM shard.tiles")
+	if err != nil {
+		return fmt.Errorf("error copying tiles from %s: %w", input, err)
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	duplicates := count - inserted
+	if duplicates == 0 {
+		return nil
+	}
+	result.Duplicates += int(duplicates)
+	if len(result.DuplicateTiles) >= mergeSampleSize {
+		return nil
+	}
+	// duplicates are the tiles which were already in the output before this shard was copied
+	rows, err := conn.QueryContext(ctx, "SELECT s.zoom_level, s.tile_column, s.tile_row FROM shard.tiles s "+
+		"JOIN main.tiles t ON t.zoom_level = s.zoom_level AND t.tile_column = s.tile_column AND t.tile_row = s.tile_row "+
+		"WHERE t.rowid <= ? LIMIT ?", lastRowID, mergeSampleSize-len(result.DuplicateTiles))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var z, x, row int
+		if err := rows.Scan(&z, &x, &row); err != nil {
+			return err
+		}
+		result.DuplicateTiles = append(result.DuplicateTiles, TileCoords{Z: z, X: x, Y: (1 << z) - 1 - row})
+	}
+	return rows.Err()
+}
+
+// findMissingTiles walks the expected tiles of each zoom alongside the sorted tiles in the output,
+// so only the expected list needs to be held in memory. Tiles the shards skipped on purpose aren't missing.
+func findMissingTiles(ctx context.Context, conn *sql.Conn, expected []TileCoords, skipped *SkippedTiles, result *MergeResult) error {
+	for _, zoomTiles := range GroupByZoom(expected) {
+		z := zoomTiles[0].Z
+		tileMax := (1 << z) - 1
+		sorted := make([]TileCoords, len(zoomTiles))
+		copy(sorted, zoomTiles)
+		// the output is sorted by column, then by TMS row
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].X != sorted[j].X {
+				return sorted[i].X < sorted[j].X
+			}
+			return tileMax-sorted[i].Y < tileMax-sorted[j].Y
+		})
+		rows, err := conn.QueryContext(ctx, "SELECT tile_column, tile_row FROM tiles WHERE zoom_level = ? ORDER BY tile_column, tile_row", z)
+		if err != nil {
+			return err
+		}
+		i := 0
+		for rows.Next() && i < len(sorted) {
+			var x, row int
+			if err := rows.Scan(&x, &row); err != nil {
+				rows.Close()
+				return err
+			}
+			for i < len(sorted) && (sorted[i].X < x || (sorted[i].X == x && tileMax-sorted[i].Y < row)) {
+				result.addMissing(sorted[i], skipped)
+				i++
+			}
+			if i < len(sorted) && sorted[i].X == x && tileMax-sorted[i].Y == row {
+				i++
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for ; i < len(sorted); i++ {
+			result.addMissing(sorted[i], skipped)
+		}
+	}
+	return nil
+}
+
+func (r *MergeResult) addMissing(tc TileCoords, skipped *SkippedTiles) {
+	if skipped.Covers(tc) {
+		return
+	}
+	r.Missing++
+	if len(r.MissingTiles) < mergeSampleSize {
+		r.MissingTiles = append(r.MissingTiles, tc)
+	}
+}
@@ -0,0 +1,136 @@
+package tileutils
+
+import (
+	"encoding/json"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twpayne/go-mbtiles"
+)
+
+func writeTestShard(t *testing.T, filename string, meta MbTilesMetadata, tiles []TileCoords) {
+	w := &MbTilesWriter{Filename: filename}
+	_, closeWriter, err := w.New()
+	require.Nil(t, err)
+	defer closeWriter()
+	require.Nil(t, w.BulkWriteMetadata(meta))
+	data := make([]mbtiles.TileData, len(tiles))
+	for i, tc := range tiles {
+		data[i] = mbtiles.TileData{Z: tc.Z, X: tc.X, Y: tc.Y, Data: []byte{byte(i)}}
+	}
+	require.Nil(t, w.BulkWrite(data))
+}
+
+func TestCheckShards(t *testing.T) {
+	inputs := []string{"a", "b", "c"}
+	assert.Nil(t, CheckShards(inputs, []MbTilesMetadata{{"shard": "2/3"}, {"shard": "1/3"}, {"shard": "3/3"}}))
+	assert.ErrorContains(t, CheckShards(inputs[:2], []MbTilesMetadata{{"shard": "2/3"}, {"shard": "1/3"}}), "missing shards: 3/3")
+	assert.ErrorContains(t, CheckShards(inputs[:2], []MbTilesMetadata{{"shard": "1/2"}, {"shard": "1/2"}}), "both shard 1/2")
+	assert.ErrorContains(t, CheckShards(inputs[:2], []MbTilesMetadata{{"shard": "1/2"}, {"shard": "2/3"}}), "split into 2 shards")
+	assert.ErrorContains(t, CheckShards(inputs[:1], []MbTilesMetadata{{}}), "a is not a shard export")
+}
+
+func TestMergeMetadata(t *testing.T) {
+	merged := MergeMetadata([]MbTilesMetadata{
+		{
+			"name":    "test",
+			"shard":   "1/2",
+			"minzoom": "2",
+			"maxzoom": "10",
+			"bounds":  "-10,-10,10,10",
+			"json":    `{"vector_layers":[{"id":"roads","minzoom":2,"maxzoom":10,"fields":{"name":"String"}}]}`,
+		},
+		{
+			"name":    "test",
+			"shard":   "2/2",
+			"minzoom": "0",
+			"maxzoom": "8",
+			"bounds":  "-20,0,5,20",
+			"json":    `{"vector_layers":[{"id":"roads","minzoom":0,"maxzoom":8,"fields":{"name":"Number","lanes":"Number"}},{"id":"water","fields":{}}]}`,
+		},
+	}, DefaultTileStatsValues)
+	assert.Equal(t, MbTilesMetadata{
+		"name":    "test",
+		"minzoom": "0",
+		"maxzoom": "10",
+		"bounds":  "-20.000000,-10.000000,10.000000,20.000000",
+		"json":    `{"vector_layers":[{"id":"roads","minzoom":0,"maxzoom":10,"fields":{"lanes":"Number","name":"String"}},{"id":"water","fields":{}}]}`,
+	}, merged)
+}
+
+func TestMergeMetadataTileStats(t *testing.T) {
+	shard := func(value string) MbTilesMetadata {
+		return MbTilesMetadata{"json": `{"vector_layers":[],"tilestats":{"layerCount":1,"layers":[{"layer":"roads","count":1,` +
+			`"attributeCount":1,"attributes":[{"attribute":"name","count":1,"type":"string","values":["` + value + `"]}]}]}}`}
+	}
+	merged := MergeMetadata([]MbTilesMetadata{shard("a"), shard("b")}, 1)
+	var metaJSON MetadataJSON
+	require.Nil(t, json.Unmarshal([]byte(merged["json"]), &metaJSON))
+	require.Len(t, metaJSON.TileStats.Layers, 1)
+	// only one sample value is kept
+	assert.Len(t, metaJSON.TileStats.Layers[0].Attributes[0].Values, 1)
+}
+
+func TestMergeMbTiles(t *testing.T) {
+	dir := t.TempDir()
+	shard1 := path.Join(dir, "shard1.mbtiles")
+	shard2 := path.Join(dir, "shard2.mbtiles")
+	output := path.Join(dir, "merged.mbtiles")
+	writeTestShard(t, shard1, MbTilesMetadata{"name": "test", "shard": "1/2", "minzoom": "1", "maxzoom": "1"}, []TileCoords{
+		{Z: 1, X: 0, Y: 0},
+		{Z: 1, X: 0, Y: 1},
+	})
+	writeTestShard(t, shard2, MbTilesMetadata{"name": "test", "shard": "2/2", "minzoom": "1", "maxzoom": "2"}, []TileCoords{
+		{Z: 1, X: 0, Y: 1},
+		{Z: 1, X: 1, Y: 1},
+		{Z: 2, X: 3, Y: 3},
+	})
+
+	expected := []TileCoords{
+		{Z: 1, X: 0, Y: 0},
+		{Z: 1, X: 0, Y: 1},
+		{Z: 1, X: 1, Y: 0},
+		{Z: 1, X: 1, Y: 1},
+		{Z: 2, X: 3, Y: 3},
+		{Z: 2, X: 0, Y: 0},
+	}
+	result, err := MergeMbTiles(output, []string{shard1, shard2}, MergeOptions{Expected: expected, TileStatsValues: DefaultTileStatsValues})
+	require.Nil(t, err)
+	assert.Equal(t, 4, result.Tiles)
+	assert.Equal(t, 1, result.Duplicates)
+	assert.Equal(t, []TileCoords{{Z: 1, X: 0, Y: 1}}, result.DuplicateTiles)
+	assert.Equal(t, 2, result.Missing)
+	assert.ElementsMatch(t, []TileCoords{{Z: 1, X: 1, Y: 0}, {Z: 2, X: 0, Y: 0}}, result.MissingTiles)
+
+	meta, err := ReadMbTilesMetadata(output)
+	require.Nil(t, err)
+	assert.Equal(t, MbTilesMetadata{"name": "test", "minzoom": "1", "maxzoom": "2"}, meta)
+
+	// tiles a shard skipped on purpose aren't missing
+	w := &MbTilesWriter{Filename: shard2, Resume: true}
+	_, closeWriter, err := w.New()
+	require.Nil(t, err)
+	require.Nil(t, w.RecordSkipped([]SkippedSubtree{{Root: TileCoords{Z: 1, X: 0, Y: 0}, Zoom: 2}}))
+	closeWriter()
+	result, err = MergeMbTiles(path.Join(dir, "skipped.mbtiles"), []string{shard1, shard2}, MergeOptions{Expected: expected, TileStatsValues: DefaultTileStatsValues})
+	require.Nil(t, err)
+	assert.Equal(t, 1, result.Missing)
+	assert.Equal(t, []TileCoords{{Z: 1, X: 1, Y: 0}}, result.MissingTiles)
+
+	// so does a shard whose export didn't finish, unless asked to merge it anyway
+	w = &MbTilesWriter{Filename: shard2, Journal: true, Resume: true}
+	_, closeWriter, err = w.New()
+	require.Nil(t, err)
+	closeWriter()
+	_, err = MergeMbTiles(path.Join(dir, "unfinished.mbtiles"), []string{shard1, shard2}, MergeOptions{})
+	assert.ErrorContains(t, err, "shard2.mbtiles is an unfinished export")
+	result, err = MergeMbTiles(path.Join(dir, "partial.mbtiles"), []string{shard1, shard2}, MergeOptions{Unfinished: true})
+	require.Nil(t, err)
+	assert.Equal(t, 4, result.Tiles)
+
+	// a missing shard fails before anything is written
+	_, err = MergeMbTiles(path.Join(dir, "other.mbtiles"), []string{shard1}, MergeOptions{})
+	assert.ErrorContains(t, err, "missing shards: 2/2")
+}
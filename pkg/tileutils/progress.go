@@ -0,0 +1,226 @@
+package tileutils
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultProgressWindow is the default time window the progress rate is measured over
+const DefaultProgressWindow = time.Minute
+
+// Progress is a snapshot of the progress of an export, written as a json line or logged
+type Progress struct {
+	Time      time.Time       `json:"time"`
+	Elapsed   float64         `json:"elapsed_seconds"`
+	Remaining float64         `json:"remaining_seconds"` // estimated, -1 until some tiles have been rendered
+	Done      int             `json:"done"`              // rendered, failed or pruned
+	Total     int             `json:"total"`
+	Percent   float64         `json:"percent"`
+	Failed    int             `json:"failed"`
+	Pruned    int             `json:"pruned"`
+	Rate      float64         `json:"rate"`    // tiles per second over the progress window
+	Workers   int             `json:"workers"` // tiles that may be rendered at once
+	Final     bool            `json:"final"`
+	Throttle  *ThrottleStatus `json:"throttle,omitempty"` // set when the export is throttled
+	Zooms     []ZoomProgress  `json:"zooms"`
+}
+
+// ZoomProgress is the progress of one zoom
+type ZoomProgress struct {
+	Zoom    int     `json:"zoom"`
+	Done    int     `json:"done"`
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"`
+}
+
+type zoomProgress struct {
+	total  int
+	done   int
+	costed int           // tiles whose render time is known, ie: not pruned
+	cost   time.Duration // total render time of the costed tiles
+}
+
+type progressSample struct {
+	at   time.Time
+	done int
+	cost time.Duration
+}
+
+// ProgressTracker follows the tiles finished at each zoom and estimates the time remaining.
+// The estimate weights the tiles left at each zoom by the render time observed at that zoom,
+// and divides it by the render time the workers get through per second over the progress window.
+// It is safe for concurrent use.
+type ProgressTracker struct {
+	mu      sync.Mutex
+	start   time.Time
+	window  time.Duration
+	zooms   map[int]*zoomProgress
+	done    int
+	failed  int
+	pruned  int
+	cost    time.Duration
+	workers int
+	samples []progressSample
+}
+
+// NewProgressTracker starts tracking the progress of an export of the tiles
+func NewProgressTracker(tiles []TileCoords, window time.Duration) *ProgressTracker {
+	p := &ProgressTracker{
+		start:  time.Now(),
+		window: window,
+		zooms:  map[int]*zoomProgress{},
+	}
+	for _, tc := range tiles {
+		p.zoom(tc.Z).total++
+	}
+	p.samples = []progressSample{{at: p.start}}
+	return p
+}
+
+func (p *ProgressTracker) zoom(z int) *zoomProgress {
+	zp, ok := p.zooms[z]
+	if !ok {
+		zp = &zoomProgress{}
+		p.zooms[z] = zp
+	}
+	return zp
+}
+
+// Rendered records a rendered tile and its render time
+func (p *ProgressTracker) Rendered(z int, cost time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finish(z, cost)
+}
+
+// Failed records a tile that failed to render after cost
+func (p *ProgressTracker) Failed(z int, cost time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finish(z, cost)
+	p.failed++
+}
+
+func (p *ProgressTracker) finish(z int, cost time.Duration) {
+	zp := p.zoom(z)
+	zp.done++
+	zp.costed++
+	zp.cost += cost
+	p.done++
+	p.cost += cost
+}
+
+// Pruned records n tiles at zoom z that won't be rendered
+func (p *ProgressTracker) Pruned(z int, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.zoom(z).done += n
+	p.done += n
+	p.pruned += n
+}
+
+// SetWorkers records the number of tiles that may be rendered at once
+func (p *ProgressTracker) SetWorkers(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.workers = n
+}
+
+// Snapshot returns the progress at now. The final snapshot has no time remaining.
+func (p *ProgressTracker) Snapshot(now time.Time, final bool) Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// keep the samples within the window, plus the last one before it to measure from
+	p.samples = append(p.samples, progressSample{at: now, done: p.done, cost: p.cost})
+	first := 0
+	for first+1 < len(p.samples) && now.Sub(p.samples[first+1].at) >= p.window {
+		first++
+	}
+	p.samples = p.samples[first:]
+	oldest := p.samples[0]
+
+	total := 0
+	for _, zp := range p.zooms {
+		total += zp.total
+	}
+	progress := Progress{
+		Time:      now,
+		Elapsed:   now.Sub(p.start).Seconds(),
+		Remaining: -1,
+		Done:      p.done,
+		Total:     total,
+		Percent:   100,
+		Failed:    p.failed,
+		Pruned:    p.pruned,
+		Workers:   p.workers,
+		Final:     final,
+		Zooms:     make([]ZoomProgress, 0, len(p.zooms)),
+	}
+	if total > 0 {
+		progress.Percent = float64(p.done) / float64(total) * 100
+	}
+	span := now.Sub(oldest.at).Seconds()
+	if span > 0 {
+		progress.Rate = float64(p.done-oldest.done) / span
+	}
+	for z, zp := range p.zooms {
+		zoom := ZoomProgress{Zoom: z, Done: zp.done, Total: zp.total, Percent: 100}
+		if zp.total > 0 {
+			zoom.Percent = float64(zp.done) / float64(zp.total) * 100
+		}
+		progress.Zooms = append(progress.Zooms, zoom)
+	}
+	sort.Slice(progress.Zooms, func(i, j int) bool { return progress.Zooms[i].Zoom < progress.Zooms[j].Zoom })
+
+	if final {
+		progress.Remaining = 0
+	} else if remaining, ok := p.remainingCost(); ok && span > 0 && p.cost > oldest.cost {
+		// render time the workers get through per second of wall-clock time
+		throughput := (p.cost - oldest.cost).Seconds() / span
+		progress.Remaining = remaining.Seconds() / throughput
+	}
+	return progress
+}
+
+// remainingCost estimates the render time of the tiles left, from the average render time at each zoom.
+// Zooms without rendered tiles yet use the closest zoom that has some.
+func (p *ProgressTracker) remainingCost() (time.Duration, bool) {
+	var remaining time.Duration
+	for z, zp := range p.zooms {
+		left := zp.total - zp.done
+		if left <= 0 {
+			continue
+		}
+		avg, ok := p.averageCost(z)
+		if !ok {
+			return 0, false
+		}
+		remaining += avg * time.Duration(left)
+	}
+	return remaining, true
+}
+
+func (p *ProgressTracker) averageCost(z int) (time.Duration, bool) {
+	best := -1
+	for oz, zp := range p.zooms {
+		if zp.costed == 0 {
+			continue
+		}
+		if best == -1 || abs(oz-z) < abs(best-z) || (abs(oz-z) == abs(best-z) && oz > best) {
+			best = oz
+		}
+	}
+	if best == -1 {
+		return 0, false
+	}
+	zp := p.zooms[best]
+	return zp.cost / time.Duration(zp.costed), true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
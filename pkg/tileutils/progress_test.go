@@ -0,0 +1,61 @@
+package tileutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressTracker(t *testing.T) {
+	tiles := []TileCoords{{Z: 0}, {Z: 1, X: 0, Y: 0}, {Z: 1, X: 0, Y: 1}, {Z: 1, X: 1, Y: 0}, {Z: 1, X: 1, Y: 1}}
+	p := NewProgressTracker(tiles, time.Minute)
+
+	// nothing done yet, so no estimate
+	progress := p.Snapshot(p.start, false)
+	assert.Equal(t, 0.0, progress.Percent)
+	assert.Equal(t, -1.0, progress.Remaining)
+	assert.Equal(t, 0.0, progress.Rate)
+
+	p.SetWorkers(8)
+	p.Rendered(0, 4*time.Second)
+	p.Rendered(1, time.Second)
+	p.Failed(1, time.Second)
+	progress = p.Snapshot(p.start.Add(2*time.Second), false)
+	assert.Equal(t, 3, progress.Done)
+	assert.Equal(t, 5, progress.Total)
+	assert.Equal(t, 1, progress.Failed)
+	assert.Equal(t, 8, progress.Workers)
+	assert.Equal(t, 60.0, progress.Percent)
+	assert.Equal(t, 1.5, progress.Rate)
+	// 2 tiles left at zoom 1 at 1s each, with workers getting through 3s of render time per second
+	assert.InDelta(t, 2.0/3, progress.Remaining, 0.001)
+	assert.Equal(t, []ZoomProgress{{Zoom: 0, Done: 1, Total: 1, Percent: 100}, {Zoom: 1, Done: 2, Total: 4, Percent: 50}}, progress.Zooms)
+
+	// the rate only counts the tiles finished within the window
+	p.Pruned(1, 1)
+	progress = p.Snapshot(p.start.Add(62*time.Second), false)
+	assert.Equal(t, 4, progress.Done)
+	assert.Equal(t, 1, progress.Pruned)
+	assert.InDelta(t, 1.0/60, progress.Rate, 0.0001)
+	assert.Equal(t, -1.0, progress.Remaining)
+
+	progress = p.Snapshot(p.start.Add(63*time.Second), true)
+	assert.True(t, progress.Final)
+	assert.Equal(t, 0.0, progress.Remaining)
+	assert.Equal(t, 80.0, progress.Percent)
+}
+
+func TestProgressTrackerAverageCost(t *testing.T) {
+	tiles := []TileCoords{{Z: 2}, {Z: 4}, {Z: 8}, {Z: 8}}
+	p := NewProgressTracker(tiles, time.Minute)
+	p.Rendered(2, time.Second)
+	p.Rendered(4, 3*time.Second)
+	// zoom 8 has no rendered tiles yet, so the closest zoom's cost is used
+	cost, ok := p.remainingCost()
+	assert.True(t, ok)
+	assert.Equal(t, 6*time.Second, cost)
+
+	empty := NewProgressTracker(nil, time.Minute).Snapshot(time.Now(), false)
+	assert.Equal(t, 100.0, empty.Percent)
+}
@@ -0,0 +1,170 @@
+package tileutils
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// zoomQueries returns the set of raw layer queries used at zoom z, keyed by layer id and sql.
+// The bool is false if any of the queries depends on $zoom, since those can't be compared between zooms.
+func zoomQueries(tj *TileJSON, z int) (map[string]struct{}, bool) {
+	queries := map[string]struct{}{}
+	for _, layer := range tj.VectorLayers {
+		for _, q := range layer.Queries {
+			if z < q.MinZoom || z >= q.MaxZoom {
+				continue
+			}
+			if strings.Contains(q.SQL, "$zoom") {
+				return nil, false
+			}
+			queries[layer.ID+"\x00"+q.SQL] = struct{}{}
+		}
+	}
+	return queries, true
+}
+
+// CanPrune reports whether a tile that rendered empty at parentZoom guarantees its descendants
+// at childZoom are empty too. This is the case when every layer query used at childZoom is also
+// used, unchanged, at parentZoom. Layers which only start at a deeper zoom than the parent
+// (or queries that change with $zoom) prevent pruning.
+//
+// Note that ST_AsMVTGeom drops geometries that collapse to nothing at the parent's resolution,
+// so very small features may be missed by pruning.
+func CanPrune(tj *TileJSON, parentZoom, childZoom int) bool {
+	if parentZoom >= childZoom {
+		return false
+	}
+	parent, ok := zoomQueries(tj, parentZoom)
+	if !ok {
+		return false
+	}
+	child, ok := zoomQueries(tj, childZoom)
+	if !ok {
+		return false
+	}
+	for q := range child {
+		if _, ok := parent[q]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// GroupByZoom splits the tiles into one list per zoom, ordered from the lowest zoom to the highest.
+// The order of the tiles within each zoom is preserved.
+func GroupByZoom(tiles []TileCoords) [][]TileCoords {
+	byZoom := map[int][]TileCoords{}
+	for _, tc := range tiles {
+		byZoom[tc.Z] = append(byZoom[tc.Z], tc)
+	}
+	zooms := make([]int, 0, len(byZoom))
+	for z := range byZoom {
+		zooms = append(zooms, z)
+	}
+	sort.Ints(zooms)
+	groups := make([][]TileCoords, 0, len(zooms))
+	for _, z := range zooms {
+		groups = append(groups, byZoom[z])
+	}
+	return groups
+}
+
+// EmptyTiles records the tiles that rendered to zero bytes, so that their descendants can be pruned.
+// It is safe for concurrent use.
+type EmptyTiles struct {
+	mu    sync.Mutex
+	tiles map[int]TileSet
+	roots map[TileCoords]TileCoords // the rendered empty tile each pruned tile descends from
+}
+
+// NewEmptyTiles creates an empty EmptyTiles set
+func NewEmptyTiles() *EmptyTiles {
+	return &EmptyTiles{
+		tiles: map[int]TileSet{},
+		roots: map[TileCoords]TileCoords{},
+	}
+}
+
+// Add records a tile as empty
+func (e *EmptyTiles) Add(tc TileCoords) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.tiles[tc.Z]; !ok {
+		e.tiles[tc.Z] = TileSet{}
+	}
+	e.tiles[tc.Z].Add(tc)
+}
+
+// Prune splits the tiles into the ones that still need to be rendered and the ones that can be
+// skipped because an ancestor was empty and CanPrune holds between the two zooms.
+// Pruned tiles are recorded as empty themselves, so pruning carries on to deeper zooms.
+func (e *EmptyTiles) Prune(tiles []TileCoords, tj *TileJSON) (kept []TileCoords, pruned []TileCoords) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// find the zooms that can be used to prune each child zoom
+	parentZooms := map[int][]int{}
+	for _, tc := range tiles {
+		if _, ok := parentZooms[tc.Z]; ok {
+			continue
+		}
+		zooms := []int{}
+		for p, set := range e.tiles {
+			if len(set) > 0 && CanPrune(tj, p, tc.Z) {
+				zooms = append(zooms, p)
+			}
+		}
+		parentZooms[tc.Z] = zooms
+	}
+
+	kept = make([]TileCoords, 0, len(tiles))
+	for _, tc := range tiles {
+		empty := false
+		for _, p := range parentZooms[tc.Z] {
+			parent := tc.Ancestor(p)
+			if e.tiles[p].Contains(parent) {
+				empty = true
+				if root, ok := e.roots[parent]; ok {
+					parent = root
+				}
+				e.roots[tc] = parent
+				break
+			}
+		}
+		if !empty {
+			kept = append(kept, tc)
+			continue
+		}
+		pruned = append(pruned, tc)
+	}
+	for _, tc := range pruned {
+		if _, ok := e.tiles[tc.Z]; !ok {
+			e.tiles[tc.Z] = TileSet{}
+		}
+		e.tiles[tc.Z].Add(tc)
+	}
+	return kept, pruned
+}
+
+// PrunedSubtrees returns the subtrees of the pruned tiles, rooted at the rendered empty tile each one
+// descends from. Every tile of such a subtree is empty, whichever shard renders it.
+func (e *EmptyTiles) PrunedSubtrees(pruned []TileCoords) []SkippedSubtree {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	seen := map[SkippedSubtree]struct{}{}
+	out := []SkippedSubtree{}
+	for _, tc := range pruned {
+		root, ok := e.roots[tc]
+		if !ok {
+			continue
+		}
+		st := SkippedSubtree{Root: root, Zoom: tc.Z}
+		if _, ok := seen[st]; ok {
+			continue
+		}
+		seen[st] = struct{}{}
+		out = append(out, st)
+	}
+	return out
+}
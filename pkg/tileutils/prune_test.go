@@ -0,0 +1,74 @@
+package tileutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanPrune(t *testing.T) {
+	tj, _, err := ParseTileJSON("./testdata/tiles.json")
+	require.Nil(t, err)
+
+	assert.True(t, CanPrune(tj, 10, 11))
+	assert.True(t, CanPrune(tj, 12, 14))
+	assert.True(t, CanPrune(tj, 3, 5))
+	// ocean switches from the simplified table at zoom 10
+	assert.False(t, CanPrune(tj, 9, 10))
+	// small labels start at zoom 12
+	assert.False(t, CanPrune(tj, 11, 12))
+	assert.False(t, CanPrune(tj, 10, 13))
+	// children must be deeper than the parent
+	assert.False(t, CanPrune(tj, 11, 10))
+
+	tj.VectorLayers[0].Queries[1].SQL = "SELECT id, tags, geom FROM osm_ocean WHERE $zoom > 10"
+	assert.False(t, CanPrune(tj, 10, 11))
+}
+
+func TestGroupByZoom(t *testing.T) {
+	tiles := []TileCoords{
+		{Z: 2, X: 1, Y: 1},
+		{Z: 1, X: 0, Y: 1},
+		{Z: 2, X: 3, Y: 0},
+	}
+	groups := GroupByZoom(tiles)
+	assert.Equal(t, [][]TileCoords{
+		{{Z: 1, X: 0, Y: 1}},
+		{{Z: 2, X: 1, Y: 1}, {Z: 2, X: 3, Y: 0}},
+	}, groups)
+}
+
+func TestEmptyTilesPrune(t *testing.T) {
+	tj, _, err := ParseTileJSON("./testdata/tiles.json")
+	require.Nil(t, err)
+
+	empty := NewEmptyTiles()
+	empty.Add(TileCoords{Z: 10, X: 0, Y: 0})
+
+	tiles := []TileCoords{
+		{Z: 11, X: 0, Y: 0},
+		{Z: 11, X: 1, Y: 1},
+		{Z: 11, X: 2, Y: 0},
+	}
+	kept, pruned := empty.Prune(tiles, tj)
+	assert.Equal(t, []TileCoords{{Z: 11, X: 2, Y: 0}}, kept)
+	assert.Equal(t, []TileCoords{{Z: 11, X: 0, Y: 0}, {Z: 11, X: 1, Y: 1}}, pruned)
+	assert.Equal(t, []SkippedSubtree{{Root: TileCoords{Z: 10, X: 0, Y: 0}, Zoom: 11}}, empty.PrunedSubtrees(pruned))
+
+	// small labels start at zoom 12, so nothing can be pruned there
+	kept, pruned = empty.Prune([]TileCoords{{Z: 12, X: 0, Y: 0}}, tj)
+	assert.Len(t, kept, 1)
+	assert.Len(t, pruned, 0)
+
+	// pruned tiles are treated as empty at deeper zooms
+	empty.Add(TileCoords{Z: 12, X: 0, Y: 0})
+	kept, pruned = empty.Prune([]TileCoords{{Z: 13, X: 1, Y: 1}, {Z: 13, X: 2, Y: 2}}, tj)
+	assert.Equal(t, []TileCoords{{Z: 13, X: 2, Y: 2}}, kept)
+	assert.Equal(t, []TileCoords{{Z: 13, X: 1, Y: 1}}, pruned)
+
+	// the subtrees of pruned descendants are rooted at the tile that rendered empty
+	kept, pruned = empty.Prune([]TileCoords{{Z: 14, X: 2, Y: 2}, {Z: 14, X: 3, Y: 3}}, tj)
+	assert.Empty(t, kept)
+	assert.Equal(t, []SkippedSubtree{{Root: TileCoords{Z: 12, X: 0, Y: 0}, Zoom: 14}}, empty.PrunedSubtrees(pruned))
+}
@@ -0,0 +1,49 @@
+package tileutils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// layerSubquery builds the expression rendering one layer of a tile as MVT, from the layer's queries at that zoom.
+// The queries are combined with a UNION, and the geometries are clipped to the tile with a margin of 64/4096.
+func layerSubquery(c TileCoords, layerName string, sqlStmts []string) string {
+	sql := "(WITH mvtgeom AS ("
+	for i, query := range sqlStmts {
+		template := "(SELECT ST_AsMVTGeom(t.geom, ST_TileEnvelope(%d, %d, %d)) AS geom, t.tags, t.id " +
+			"FROM (%s) AS t " +
+			"WHERE t.geom && ST_TileEnvelope(%d, %d, %d, margin => (64.0/4096)))"
+		_sql := fmt.Sprintf(template,
+			c.Z, c.X, c.Y,
+			strings.ReplaceAll(query, ";", ""),
+			c.Z, c.X, c.Y)
+		if i != 0 {
+			sql += " UNION "
+		}
+		sql += _sql
+	}
+	return sql + fmt.Sprintf(") SELECT ST_AsMVT(mvtgeom.*, '%s') FROM mvtgeom )", layerName)
+}
+
+// LayerQuery builds the query rendering one layer of a tile as MVT
+func LayerQuery(c TileCoords, layerName string, sqlStmts []string) string {
+	return "SELECT " + layerSubquery(c, layerName, sqlStmts) + " mvtTile;"
+}
+
+// TileQuery builds the single query rendering every layer of a tile as MVT, concatenated in name order
+func TileQuery(c TileCoords, layers map[string][]string) string {
+	names := make([]string, 0, len(layers))
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	queryStr := "SELECT "
+	for i, name := range names {
+		if i > 0 {
+			queryStr += "||"
+		}
+		queryStr += layerSubquery(c, name, layers[name])
+	}
+	return queryStr + " mvtTile;"
+}
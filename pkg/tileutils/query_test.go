@@ -0,0 +1,30 @@
+package tileutils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayerQuery(t *testing.T) {
+	sql := LayerQuery(TileCoords{Z: 3, X: 2, Y: 1}, "roads", []string{"SELECT id, tags, geom FROM roads;", "SELECT id, tags, geom FROM paths"})
+	assert.Equal(t, "SELECT (WITH mvtgeom AS ("+
+		"(SELECT ST_AsMVTGeom(t.geom, ST_TileEnvelope(3, 2, 1)) AS geom, t.tags, t.id "+
+		"FROM (SELECT id, tags, geom FROM roads) AS t "+
+		"WHERE t.geom && ST_TileEnvelope(3, 2, 1, margin => (64.0/4096)))"+
+		" UNION "+
+		"(SELECT ST_AsMVTGeom(t.geom, ST_TileEnvelope(3, 2, 1)) AS geom, t.tags, t.id "+
+		"FROM (SELECT id, tags, geom FROM paths) AS t "+
+		"WHERE t.geom && ST_TileEnvelope(3, 2, 1, margin => (64.0/4096)))"+
+		") SELECT ST_AsMVT(mvtgeom.*, 'roads') FROM mvtgeom ) mvtTile;", sql)
+}
+
+func TestTileQuery(t *testing.T) {
+	c := TileCoords{Z: 3, X: 2, Y: 1}
+	layers := map[string][]string{"roads": {"SELECT id, tags, geom FROM roads"}, "water": {"SELECT id, tags, geom FROM water"}}
+	sql := TileQuery(c, layers)
+	roads := strings.TrimSuffix(strings.TrimPrefix(LayerQuery(c, "roads", layers["roads"]), "SELECT "), " mvtTile;")
+	water := strings.TrimSuffix(strings.TrimPrefix(LayerQuery(c, "water", layers["water"]), "SELECT "), " mvtTile;")
+	assert.Equal(t, "SELECT "+roads+"||"+water+" mvtTile;", sql)
+}
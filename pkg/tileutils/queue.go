@@ -0,0 +1,77 @@
+package tileutils
+
+import "sync"
+
+const (
+	// batchBaseZoom is the deepest zoom where tiles are handed out one at a time
+	batchBaseZoom = 8
+	// maxBatchSize is the largest number of tiles handed out at once
+	maxBatchSize = 32
+)
+
+// BatchSize returns how many tiles are handed out at once at zoom z. Low zoom tiles are
+// expensive, so they are handed out one by one. Deeper tiles are cheap, so the batch size
+// doubles with each zoom past batchBaseZoom to reduce contention on the queue.
+func BatchSize(z int) int {
+	if z <= batchBaseZoom {
+		return 1
+	}
+	if z-batchBaseZoom >= 5 {
+		return maxBatchSize
+	}
+	return 1 << (z - batchBaseZoom)
+}
+
+// TileQueue is a shared queue of tiles that workers pull batches from, so a worker that
+// draws slow tiles doesn't hold up the others. Tiles are handed out in their original
+// order, which keeps the workers on neighboring tiles. It is safe for concurrent use.
+type TileQueue struct {
+	mu      sync.Mutex
+	tiles   []TileCoords
+	singles int // the leading tiles which are handed out one at a time
+	next    int
+}
+
+// NewTileQueue creates a queue of the tiles. The first singles tiles, eg: the slow tiles moved to the
+// front by SlowestFirst, are handed out one at a time whatever their zoom, so they spread over the workers.
+func NewTileQueue(tiles []TileCoords, singles int) *TileQueue {
+	return &TileQueue{
+		tiles:   tiles,
+		singles: singles,
+	}
+}
+
+// Next returns the next batch of tiles, or nil once the queue is empty.
+// All the tiles in a batch are at the same zoom.
+func (q *TileQueue) Next() []TileCoords {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.next >= len(q.tiles) {
+		return nil
+	}
+	start := q.next
+	if start < q.singles {
+		q.next++
+		return q.tiles[start:q.next]
+	}
+	z := q.tiles[start].Z
+	end := start + BatchSize(z)
+	if end > len(q.tiles) {
+		end = len(q.tiles)
+	}
+	for i := start + 1; i < end; i++ {
+		if q.tiles[i].Z != z {
+			end = i
+			break
+		}
+	}
+	q.next = end
+	return q.tiles[start:end]
+}
+
+// Len returns the number of tiles left in the queue
+func (q *TileQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tiles) - q.next
+}
@@ -0,0 +1,74 @@
+package tileutils
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchSize(t *testing.T) {
+	assert.Equal(t, 1, BatchSize(0))
+	assert.Equal(t, 1, BatchSize(8))
+	assert.Equal(t, 2, BatchSize(9))
+	assert.Equal(t, 16, BatchSize(12))
+	assert.Equal(t, 32, BatchSize(14))
+	assert.Equal(t, 32, BatchSize(20))
+}
+
+func TestTileQueue(t *testing.T) {
+	tiles := []TileCoords{
+		{Z: 8, X: 0, Y: 0},
+		{Z: 8, X: 1, Y: 0},
+		{Z: 9, X: 0, Y: 0},
+		{Z: 9, X: 0, Y: 1},
+		{Z: 9, X: 1, Y: 0},
+		{Z: 10, X: 0, Y: 0},
+	}
+	q := NewTileQueue(tiles, 0)
+	assert.Equal(t, 6, q.Len())
+	assert.Equal(t, tiles[0:1], q.Next())
+	assert.Equal(t, tiles[1:2], q.Next())
+	assert.Equal(t, tiles[2:4], q.Next())
+	// batches never cross zooms
+	assert.Equal(t, tiles[4:5], q.Next())
+	assert.Equal(t, tiles[5:6], q.Next())
+	assert.Nil(t, q.Next())
+	assert.Equal(t, 0, q.Len())
+
+	// the leading slow tiles are handed out one at a time, the rest in batches
+	tiles = []TileCoords{
+		{Z: 14, X: 0, Y: 0},
+		{Z: 14, X: 0, Y: 1},
+		{Z: 14, X: 1, Y: 0},
+		{Z: 14, X: 1, Y: 1},
+	}
+	q = NewTileQueue(tiles, 2)
+	assert.Equal(t, tiles[0:1], q.Next())
+	assert.Equal(t, tiles[1:2], q.Next())
+	assert.Equal(t, tiles[2:4], q.Next())
+	assert.Nil(t, q.Next())
+}
+
+func TestTileQueueConcurrent(t *testing.T) {
+	tiles := ListTiles([]int{10}, &TileJSON{Bounds: []float64{-180, -85, 180, 85}})
+	q := NewTileQueue(tiles, 0)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := TileSet{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := q.Next(); batch != nil; batch = q.Next() {
+				mu.Lock()
+				for _, tc := range batch {
+					seen.Add(tc)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, seen, len(tiles))
+}
@@ -0,0 +1,357 @@
+package tileutils
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/twpayne/go-mbtiles"
+)
+
+// DefaultReportTopTiles is the default number of slowest and largest tiles listed in a run report
+const DefaultReportTopTiles = 20
+
+// histogramGrowth is the ratio between the bounds of consecutive duration buckets,
+// so the percentiles of a run report are within 5% of the exact ones
+const histogramGrowth = 1.05
+
+// RunReport is the machine-readable summary of an export, written at the end of a run.
+// Everything is sorted, so reports of different runs can be diffed.
+type RunReport struct {
+	Started      time.Time     `json:"started"`
+	Finished     time.Time     `json:"finished"`
+	Seconds      float64       `json:"seconds"`
+	Complete     bool          `json:"complete"`      // every tile was rendered and written, without interruption
+	ResumedTiles int           `json:"resumed_tiles"` // tiles already written by a previous run, with --resume
+	Phases       []PhaseReport `json:"phases"`
+	Zooms        []ZoomReport  `json:"zooms"`
+	Layers       []LayerReport `json:"layers"`
+	Writer       WriterReport  `json:"writer"`
+	Slowest      []TileReport  `json:"slowest_tiles"`
+	Largest      []TileReport  `json:"largest_tiles"`
+}
+
+// PhaseReport is the wall-clock time spent in one phase of the export
+type PhaseReport struct {
+	Phase   string  `json:"phase"`
+	Seconds float64 `json:"seconds"`
+}
+
+// ZoomReport has the tile counts, sizes and query times of one zoom
+type ZoomReport struct {
+	Zoom     int     `json:"zoom"`
+	Rendered int     `json:"rendered"`
+	Failed   int     `json:"failed"`
+	Empty    int     `json:"empty"`   // rendered without any features
+	Pruned   int     `json:"pruned"`  // skipped because their parent was empty
+	Written  int     `json:"written"` // tiles written to the output, including canned empty tiles
+	Bytes    int64   `json:"bytes"`   // bytes written to the output, after compression
+	QueryP50 float64 `json:"query_p50_ms"`
+	QueryP95 float64 `json:"query_p95_ms"`
+	QueryMax float64 `json:"query_max_ms"`
+}
+
+// LayerReport is the share of the rendered bytes taken by one layer
+type LayerReport struct {
+	Layer string  `json:"layer"`
+	Bytes int64   `json:"bytes"`
+	Share float64 `json:"share"` // fraction of the bytes of all layers
+}
+
+// WriterReport is the throughput of the writes to the output
+type WriterReport struct {
+	Tiles          int     `json:"tiles"`
+	Bytes          int64   `json:"bytes"`
+	Seconds        float64 `json:"seconds"` // time spent in writes, by the single mbtiles writer or summed over the workers writing files
+	TilesPerSecond float64 `json:"tiles_per_second"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+// TileReport is one of the slowest or largest tiles
+type TileReport struct {
+	Z       int     `json:"z"`
+	X       int     `json:"x"`
+	Y       int     `json:"y"`
+	QueryMs float64 `json:"query_ms"`
+	Size    int     `json:"size"` // bytes of the rendered tile, before compression
+}
+
+// durationHistogram counts durations in buckets that grow exponentially, to estimate percentiles in bounded memory
+type durationHistogram struct {
+	counts map[int]int
+	n      int
+	max    time.Duration
+}
+
+func durationBucket(d time.Duration) int {
+	if d < time.Microsecond {
+		return 0
+	}
+	return int(math.Log(float64(d)/float64(time.Microsecond)) / math.Log(histogramGrowth))
+}
+
+func (h *durationHistogram) Add(d time.Duration) {
+	if h.counts == nil {
+		h.counts = map[int]int{}
+	}
+	h.counts[durationBucket(d)]++
+	h.n++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Quantile returns the upper bound of the bucket holding the q quantile, never more than the largest duration
+func (h *durationHistogram) Quantile(q float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	buckets := make([]int, 0, len(h.counts))
+	for b := range h.counts {
+		buckets = append(buckets, b)
+	}
+	sort.Ints(buckets)
+	rank := int(math.Ceil(q * float64(h.n)))
+	seen := 0
+	for _, b := range buckets {
+		seen += h.counts[b]
+		if seen >= rank {
+			upper := time.Duration(math.Pow(histogramGrowth, float64(b+1)) * float64(time.Microsecond))
+			if upper > h.max {
+				return h.max
+			}
+			return upper
+		}
+	}
+	return h.max
+}
+
+// topTiles keeps the n tiles with the largest value, sorted from largest to smallest
+type topTiles struct {
+	n     int
+	tiles []TileReport
+	value func(TileReport) float64
+}
+
+func (t *topTiles) Add(tile TileReport) {
+	v := t.value(tile)
+	if len(t.tiles) == t.n && (t.n == 0 || v <= t.value(t.tiles[t.n-1])) {
+		return
+	}
+	i := sort.Search(len(t.tiles), func(i int) bool { return t.value(t.tiles[i]) < v })
+	if len(t.tiles) < t.n {
+		t.tiles = append(t.tiles, TileReport{})
+	}
+	copy(t.tiles[i+1:], t.tiles[i:])
+	t.tiles[i] = tile
+}
+
+type zoomStats struct {
+	report  ZoomReport
+	queries durationHistogram
+}
+
+// ReportCollector gathers the stats of an export for its RunReport.
+// It is safe for concurrent use, and a nil ReportCollector records nothing.
+type ReportCollector struct {
+	mu         sync.Mutex
+	started    time.Time
+	phase      string
+	phaseStart time.Time
+	phases     []PhaseReport
+	zooms      map[int]*zoomStats
+	layers     map[string]int64
+	writer     WriterReport
+	writeTime  time.Duration
+	slowest    topTiles
+	largest    topTiles
+	resumed    int
+}
+
+// NewReportCollector starts collecting the stats of an export, listing the topN slowest and largest tiles
+func NewReportCollector(topN int) *ReportCollector {
+	now := time.Now()
+	return &ReportCollector{
+		started:    now,
+		phaseStart: now,
+		zooms:      map[int]*zoomStats{},
+		layers:     map[string]int64{},
+		slowest:    topTiles{n: topN, value: func(t TileReport) float64 { return t.QueryMs }},
+		largest:    topTiles{n: topN, value: func(t TileReport) float64 { return float64(t.Size) }},
+	}
+}
+
+// Phase ends the current phase of the export, if any, and starts the named one
+func (r *ReportCollector) Phase(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endPhase(time.Now())
+	r.phase = name
+}
+
+// endPhase adds the time since the phase started to it, phases entered more than once are only listed once
+func (r *ReportCollector) endPhase(now time.Time) {
+	if r.phase != "" {
+		seconds := now.Sub(r.phaseStart).Seconds()
+		i := 0
+		for i < len(r.phases) && r.phases[i].Phase != r.phase {
+			i++
+		}
+		if i == len(r.phases) {
+			r.phases = append(r.phases, PhaseReport{Phase: r.phase})
+		}
+		r.phases[i].Seconds += seconds
+	}
+	r.phase = ""
+	r.phaseStart = now
+}
+
+func (r *ReportCollector) zoom(z int) *zoomStats {
+	zs, ok := r.zooms[z]
+	if !ok {
+		zs = &zoomStats{report: ZoomReport{Zoom: z}}
+		r.zooms[z] = zs
+	}
+	return zs
+}
+
+// Resumed records the number of tiles skipped because a previous run wrote them
+func (r *ReportCollector) Resumed(n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resumed = n
+}
+
+// TileRendered records a rendered tile, with the time its queries took and its size before compression
+func (r *ReportCollector) TileRendered(tc TileCoords, query time.Duration, size int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	zs := r.zoom(tc.Z)
+	zs.report.Rendered++
+	if size == 0 {
+		zs.report.Empty++
+	}
+	zs.queries.Add(query)
+	tile := TileReport{Z: tc.Z, X: tc.X, Y: tc.Y, QueryMs: durationMs(query), Size: size}
+	r.slowest.Add(tile)
+	r.largest.Add(tile)
+}
+
+// TileFailed records a tile whose queries failed
+func (r *ReportCollector) TileFailed(tc TileCoords) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.zoom(tc.Z).report.Failed++
+}
+
+// TilesPruned records n tiles at zoom z that were skipped because their parent was empty
+func (r *ReportCollector) TilesPruned(z int, n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.zoom(z).report.Pruned += n
+}
+
+// LayerRendered records the bytes of one layer of a rendered tile
+func (r *ReportCollector) LayerRendered(layer string, size int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.layers[layer] += int64(size)
+}
+
+// Written records tiles written to the output and how long the write took
+func (r *ReportCollector) Written(data []mbtiles.TileData, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range data {
+		zs := r.zoom(t.Z)
+		zs.report.Written++
+		zs.report.Bytes += int64(len(t.Data))
+		r.writer.Bytes += int64(len(t.Data))
+	}
+	r.writer.Tiles += len(data)
+	r.writeTime += d
+}
+
+// Report ends the current phase and returns the report of the export so far
+func (r *ReportCollector) Report(complete bool) *RunReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.endPhase(now)
+	report := &RunReport{
+		Started:      r.started,
+		Finished:     now,
+		Seconds:      now.Sub(r.started).Seconds(),
+		Complete:     complete,
+		ResumedTiles: r.resumed,
+		Phases:       append([]PhaseReport{}, r.phases...),
+		Zooms:        make([]ZoomReport, 0, len(r.zooms)),
+		Layers:       make([]LayerReport, 0, len(r.layers)),
+		Writer:       r.writer,
+		Slowest:      append([]TileReport{}, r.slowest.tiles...),
+		Largest:      append([]TileReport{}, r.largest.tiles...),
+	}
+	for _, zs := range r.zooms {
+		zr := zs.report
+		zr.QueryP50 = durationMs(zs.queries.Quantile(0.5))
+		zr.QueryP95 = durationMs(zs.queries.Quantile(0.95))
+		zr.QueryMax = durationMs(zs.queries.max)
+		report.Zooms = append(report.Zooms, zr)
+	}
+	sort.Slice(report.Zooms, func(i, j int) bool { return report.Zooms[i].Zoom < report.Zooms[j].Zoom })
+	var layerBytes int64
+	for _, b := range r.layers {
+		layerBytes += b
+	}
+	for name, b := range r.layers {
+		layer := LayerReport{Layer: name, Bytes: b}
+		if layerBytes > 0 {
+			layer.Share = float64(b) / float64(layerBytes)
+		}
+		report.Layers = append(report.Layers, layer)
+	}
+	sort.Slice(report.Layers, func(i, j int) bool { return report.Layers[i].Layer < report.Layers[j].Layer })
+	report.Writer.Seconds = r.writeTime.Seconds()
+	if r.writeTime > 0 {
+		report.Writer.TilesPerSecond = float64(r.writer.Tiles) / r.writeTime.Seconds()
+		report.Writer.BytesPerSecond = float64(r.writer.Bytes) / r.writeTime.Seconds()
+	}
+	return report
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteRunReport writes the report to filename as indented json
+func WriteRunReport(filename string, report *RunReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
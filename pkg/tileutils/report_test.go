@@ -0,0 +1,82 @@
+package tileutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twpayne/go-mbtiles"
+)
+
+func TestDurationHistogram(t *testing.T) {
+	var h durationHistogram
+	assert.Equal(t, time.Duration(0), h.Quantile(0.5))
+	for i := 1; i <= 100; i++ {
+		h.Add(time.Duration(i) * time.Millisecond)
+	}
+	assert.InEpsilon(t, 50*time.Millisecond, h.Quantile(0.5), 0.05)
+	assert.InEpsilon(t, 95*time.Millisecond, h.Quantile(0.95), 0.05)
+	assert.Equal(t, 100*time.Millisecond, h.Quantile(1))
+}
+
+func TestTopTiles(t *testing.T) {
+	top := topTiles{n: 2, value: func(t TileReport) float64 { return float64(t.Size) }}
+	top.Add(TileReport{X: 1, Size: 10})
+	top.Add(TileReport{X: 2, Size: 30})
+	top.Add(TileReport{X: 3, Size: 5})
+	top.Add(TileReport{X: 4, Size: 20})
+	assert.Equal(t, []TileReport{{X: 2, Size: 30}, {X: 4, Size: 20}}, top.tiles)
+}
+
+func TestReportCollector(t *testing.T) {
+	r := NewReportCollector(1)
+	r.Phase("list")
+	r.Phase("render")
+	r.Phase("list")
+	r.Phase("render")
+	r.Resumed(7)
+	r.TileRendered(TileCoords{Z: 1, X: 0, Y: 1}, 10*time.Millisecond, 100)
+	r.TileRendered(TileCoords{Z: 1, X: 1, Y: 1}, 30*time.Millisecond, 0)
+	r.TileRendered(TileCoords{Z: 0, X: 0, Y: 0}, 20*time.Millisecond, 300)
+	r.TileFailed(TileCoords{Z: 1, X: 1, Y: 0})
+	r.TilesPruned(2, 4)
+	r.LayerRendered("roads", 300)
+	r.LayerRendered("water", 100)
+	r.Written([]mbtiles.TileData{{Z: 1, X: 0, Y: 1, Data: make([]byte, 40)}, {Z: 0, X: 0, Y: 0, Data: make([]byte, 60)}}, time.Second)
+	report := r.Report(false)
+
+	require.Len(t, report.Phases, 2)
+	assert.Equal(t, "list", report.Phases[0].Phase)
+	assert.Equal(t, "render", report.Phases[1].Phase)
+	assert.False(t, report.Complete)
+	assert.Equal(t, 7, report.ResumedTiles)
+
+	require.Len(t, report.Zooms, 3)
+	assert.Equal(t, ZoomReport{Zoom: 0, Rendered: 1, Written: 1, Bytes: 60, QueryP50: 20, QueryP95: 20, QueryMax: 20}, report.Zooms[0])
+	assert.Equal(t, 1, report.Zooms[1].Zoom)
+	assert.Equal(t, 2, report.Zooms[1].Rendered)
+	assert.Equal(t, 1, report.Zooms[1].Failed)
+	assert.Equal(t, 1, report.Zooms[1].Empty)
+	assert.Equal(t, 1, report.Zooms[1].Written)
+	assert.Equal(t, int64(40), report.Zooms[1].Bytes)
+	assert.Equal(t, 30.0, report.Zooms[1].QueryMax)
+	assert.Equal(t, ZoomReport{Zoom: 2, Pruned: 4}, report.Zooms[2])
+
+	assert.Equal(t, []LayerReport{{Layer: "roads", Bytes: 300, Share: 0.75}, {Layer: "water", Bytes: 100, Share: 0.25}}, report.Layers)
+	assert.Equal(t, WriterReport{Tiles: 2, Bytes: 100, Seconds: 1, TilesPerSecond: 2, BytesPerSecond: 100}, report.Writer)
+	assert.Equal(t, []TileReport{{Z: 1, X: 1, Y: 1, QueryMs: 30, Size: 0}}, report.Slowest)
+	assert.Equal(t, []TileReport{{Z: 0, X: 0, Y: 0, QueryMs: 20, Size: 300}}, report.Largest)
+}
+
+func TestNilReportCollector(t *testing.T) {
+	var r *ReportCollector
+	// without --report nothing is collected
+	r.Phase("render")
+	r.Resumed(1)
+	r.TileRendered(TileCoords{Z: 1}, time.Second, 10)
+	r.TileFailed(TileCoords{Z: 1})
+	r.TilesPruned(2, 4)
+	r.LayerRendered("roads", 10)
+	r.Written([]mbtiles.TileData{{Z: 1}}, time.Second)
+}
@@ -0,0 +1,73 @@
+package tileutils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// shardBlockZoom is the zoom of the blocks that are assigned to shards.
+// Tiles at or below this zoom are assigned individually, deeper tiles follow their ancestor
+// at this zoom, so a shard always exports whole neighborhoods and all of their descendants.
+const shardBlockZoom = 8
+
+// Shard is one of Count disjoint partitions of the tiles. Index starts at 1.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// ParseShard parses a shard in the form i/n, where 1 <= i <= n
+func ParseShard(s string) (Shard, error) {
+	index, count, ok := strings.Cut(s, "/")
+	if !ok {
+		return Shard{}, fmt.Errorf("invalid shard (%s), expected i/n", s)
+	}
+	var shard Shard
+	var err error
+	if shard.Index, err = strconv.Atoi(index); err != nil {
+		return Shard{}, fmt.Errorf("invalid shard (%s): %w", s, err)
+	}
+	if shard.Count, err = strconv.Atoi(count); err != nil {
+		return Shard{}, fmt.Errorf("invalid shard (%s): %w", s, err)
+	}
+	if shard.Count < 1 || shard.Index < 1 || shard.Index > shard.Count {
+		return Shard{}, fmt.Errorf("invalid shard (%s), expected 1 <= i <= n", s)
+	}
+	return shard, nil
+}
+
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// ShardOf returns the index (starting at 1) of the shard the tile belongs to when split into count shards.
+// The assignment only depends on the tile's coordinates, so every process agrees on it.
+func ShardOf(tc TileCoords, count int) int {
+	block := tc.Ancestor(shardBlockZoom)
+	var buf [24]byte
+	binary.LittleEndian.PutUint64(buf[0:], uint64(block.Z))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(block.X))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(block.Y))
+	h := fnv.New64a()
+	h.Write(buf[:])
+	return int(h.Sum64()%uint64(count)) + 1
+}
+
+// Contains reports whether the tile belongs to this shard
+func (s Shard) Contains(tc TileCoords) bool {
+	return ShardOf(tc, s.Count) == s.Index
+}
+
+// ShardTiles returns the tiles that belong to the shard, keeping their order
+func ShardTiles(tiles []TileCoords, shard Shard) []TileCoords {
+	out := make([]TileCoords, 0, len(tiles)/shard.Count+1)
+	for _, tc := range tiles {
+		if shard.Contains(tc) {
+			out = append(out, tc)
+		}
+	}
+	return out
+}
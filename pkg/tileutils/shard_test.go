@@ -0,0 +1,38 @@
+package tileutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseShard(t *testing.T) {
+	shard, err := ParseShard("2/4")
+	assert.Nil(t, err)
+	assert.Equal(t, Shard{Index: 2, Count: 4}, shard)
+	assert.Equal(t, "2/4", shard.String())
+
+	for _, s := range []string{"0/4", "5/4", "1/0", "1", "a/2", "1/b"} {
+		_, err := ParseShard(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestShardTiles(t *testing.T) {
+	tiles := ListTiles([]int{6, 10}, &TileJSON{Bounds: []float64{-180, -85, 180, 85}})
+	shards := []Shard{{Index: 1, Count: 3}, {Index: 2, Count: 3}, {Index: 3, Count: 3}}
+
+	// every tile is in exactly one shard
+	total := 0
+	for _, shard := range shards {
+		shardTiles := ShardTiles(tiles, shard)
+		assert.Greater(t, len(shardTiles), len(tiles)/6)
+		total += len(shardTiles)
+	}
+	assert.Equal(t, len(tiles), total)
+
+	// descendants below the block zoom stay in the same shard
+	parent := TileCoords{Z: 9, X: 100, Y: 200}
+	child := TileCoords{Z: 14, X: 100<<5 + 7, Y: 200<<5 + 3}
+	assert.Equal(t, ShardOf(parent, 3), ShardOf(child, 3))
+}
@@ -0,0 +1,153 @@
+package tileutils
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+)
+
+// skippedTable records the tiles an export left out on purpose, because they have no source data
+// (--coverage-zoom) or an empty ancestor (--hierarchical), so a merge doesn't report them as missing.
+// Each row covers every tile at zoom under the z/x/y root tile. It is kept once the export is complete.
+const skippedTable = "skipped_tiles"
+
+const createSkippedSQL = "CREATE TABLE IF NOT EXISTS " + skippedTable +
+	" (z INTEGER NOT NULL, x INTEGER NOT NULL, y INTEGER NOT NULL, zoom INTEGER NOT NULL, PRIMARY KEY (z, x, y, zoom)) WITHOUT ROWID"
+
+// SkippedSubtree is the set of tiles at Zoom which are descendants of Root
+type SkippedSubtree struct {
+	Root TileCoords
+	Zoom int
+}
+
+// SkippedTiles is a set of tiles left out of an export, kept as subtrees so it stays small
+// even when most of the world is skipped. It is not safe for concurrent use.
+type SkippedTiles struct {
+	subtrees  map[SkippedSubtree]struct{}
+	rootZooms []int // sorted zooms of the roots, to look up the ancestors of a tile
+}
+
+// NewSkippedTiles creates an empty set of skipped tiles
+func NewSkippedTiles() *SkippedTiles {
+	return &SkippedTiles{subtrees: map[SkippedSubtree]struct{}{}}
+}
+
+// Add records the subtrees as skipped
+func (s *SkippedTiles) Add(subtrees []SkippedSubtree) {
+	for _, st := range subtrees {
+		if _, ok := s.subtrees[st]; ok {
+			continue
+		}
+		s.subtrees[st] = struct{}{}
+		i := sort.SearchInts(s.rootZooms, st.Root.Z)
+		if i == len(s.rootZooms) || s.rootZooms[i] != st.Root.Z {
+			s.rootZooms = append(s.rootZooms, 0)
+			copy(s.rootZooms[i+1:], s.rootZooms[i:])
+			s.rootZooms[i] = st.Root.Z
+		}
+	}
+}
+
+// Len returns the number of subtrees in the set
+func (s *SkippedTiles) Len() int {
+	return len(s.subtrees)
+}
+
+// Subtrees returns the subtrees in the set, ordered by root, then zoom
+func (s *SkippedTiles) Subtrees() []SkippedSubtree {
+	out := make([]SkippedSubtree, 0, len(s.subtrees))
+	for st := range s.subtrees {
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Root != b.Root {
+			return tileKey(a.Root) < tileKey(b.Root)
+		}
+		return a.Zoom < b.Zoom
+	})
+	return out
+}
+
+// Covers reports whether the tile was skipped
+func (s *SkippedTiles) Covers(tc TileCoords) bool {
+	for _, z := range s.rootZooms {
+		if z > tc.Z {
+			break
+		}
+		if _, ok := s.subtrees[SkippedSubtree{Root: tc.Ancestor(z), Zoom: tc.Z}]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CoverageSubtrees returns the subtrees of the tiles skipped by FilterCoverage, rooted at their
+// ancestor at coverageZoom
+func CoverageSubtrees(skipped []TileCoords, coverageZoom int) []SkippedSubtree {
+	seen := map[SkippedSubtree]struct{}{}
+	out := []SkippedSubtree{}
+	for _, tc := range skipped {
+		st := SkippedSubtree{Root: tc.Ancestor(coverageZoom), Zoom: tc.Z}
+		if _, ok := seen[st]; ok {
+			continue
+		}
+		seen[st] = struct{}{}
+		out = append(out, st)
+	}
+	return out
+}
+
+// RecordSkipped adds the subtrees to the skipped tiles table of the mbtiles
+func (w *MbTilesWriter) RecordSkipped(subtrees []SkippedSubtree) error {
+	if _, err := w.DB.Exec(createSkippedSQL); err != nil {
+		return err
+	}
+	tx, err := w.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("INSERT OR IGNORE INTO " + skippedTable + " (z, x, y, zoom) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, st := range subtrees {
+		if _, err := stmt.Exec(st.Root.Z, st.Root.X, st.Root.Y, st.Zoom); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// readSkipped adds the skipped tiles recorded in the attached shard database to skipped.
+// Shards written without the table add nothing.
+func readSkipped(ctx context.Context, conn *sql.Conn, skipped *SkippedTiles) error {
+	var name string
+	err := conn.QueryRowContext(ctx, "SELECT name FROM shard.sqlite_master WHERE type = 'table' AND name = ?", skippedTable).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT z, x, y, zoom FROM shard."+skippedTable)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	subtrees := []SkippedSubtree{}
+	for rows.Next() {
+		var st SkippedSubtree
+		if err := rows.Scan(&st.Root.Z, &st.Root.X, &st.Root.Y, &st.Zoom); err != nil {
+			return err
+		}
+		subtrees = append(subtrees, st)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	skipped.Add(subtrees)
+	return nil
+}
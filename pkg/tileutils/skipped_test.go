@@ -0,0 +1,63 @@
+package tileutils
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkippedTiles(t *testing.T) {
+	skipped := NewSkippedTiles()
+	skipped.Add([]SkippedSubtree{
+		{Root: TileCoords{Z: 3, X: 1, Y: 2}, Zoom: 3},
+		{Root: TileCoords{Z: 3, X: 1, Y: 2}, Zoom: 5},
+		{Root: TileCoords{Z: 1, X: 1, Y: 1}, Zoom: 4},
+		{Root: TileCoords{Z: 1, X: 1, Y: 1}, Zoom: 4},
+	})
+	assert.Equal(t, 3, skipped.Len())
+	assert.Equal(t, []SkippedSubtree{
+		{Root: TileCoords{Z: 1, X: 1, Y: 1}, Zoom: 4},
+		{Root: TileCoords{Z: 3, X: 1, Y: 2}, Zoom: 3},
+		{Root: TileCoords{Z: 3, X: 1, Y: 2}, Zoom: 5},
+	}, skipped.Subtrees())
+
+	assert.True(t, skipped.Covers(TileCoords{Z: 3, X: 1, Y: 2}))
+	assert.True(t, skipped.Covers(TileCoords{Z: 5, X: 7, Y: 11}))
+	assert.True(t, skipped.Covers(TileCoords{Z: 4, X: 9, Y: 9}))
+	// only the recorded zooms of a subtree are skipped
+	assert.False(t, skipped.Covers(TileCoords{Z: 4, X: 2, Y: 4}))
+	assert.False(t, skipped.Covers(TileCoords{Z: 1, X: 1, Y: 1}))
+	assert.False(t, skipped.Covers(TileCoords{Z: 5, X: 0, Y: 0}))
+	assert.False(t, skipped.Covers(TileCoords{Z: 3, X: 2, Y: 2}))
+}
+
+func TestCoverageSubtrees(t *testing.T) {
+	subtrees := CoverageSubtrees([]TileCoords{
+		{Z: 2, X: 1, Y: 1},
+		{Z: 3, X: 2, Y: 2},
+		{Z: 3, X: 3, Y: 2},
+		{Z: 3, X: 0, Y: 0},
+	}, 2)
+	assert.Equal(t, []SkippedSubtree{
+		{Root: TileCoords{Z: 2, X: 1, Y: 1}, Zoom: 2},
+		{Root: TileCoords{Z: 2, X: 1, Y: 1}, Zoom: 3},
+		{Root: TileCoords{Z: 2, X: 0, Y: 0}, Zoom: 3},
+	}, subtrees)
+}
+
+func TestRecordSkipped(t *testing.T) {
+	w := &MbTilesWriter{Filename: path.Join(t.TempDir(), "out.mbtiles")}
+	_, closeWriter, err := w.New()
+	require.Nil(t, err)
+	defer closeWriter()
+
+	subtrees := []SkippedSubtree{{Root: TileCoords{Z: 2, X: 1, Y: 3}, Zoom: 4}}
+	require.Nil(t, w.RecordSkipped(subtrees))
+	// recording the same subtree again is fine, eg: when resuming
+	require.Nil(t, w.RecordSkipped(subtrees))
+	var count int
+	require.Nil(t, w.DB.QueryRow("SELECT COUNT(*) FROM "+skippedTable).Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,280 @@
+package tileutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttlePausePoll is how often paused queries check whether the schedule lets them run again
+const throttlePausePoll = 10 * time.Second
+
+// ThrottleReleaseDelay is the shortest throttle delay worth giving back a database connection for, like a pause.
+// Shorter delays, eg: spacing queries or refilling the query time budget after a query, are waited out holding it.
+const ThrottleReleaseDelay = throttlePausePoll
+
+// ThrottleWindow applies a percentage of the throttle limits during a time of day.
+// To is exclusive and may be before From, for a window that spans midnight.
+type ThrottleWindow struct {
+	From    string  `json:"from"` // HH:MM, local time
+	To      string  `json:"to"`   // HH:MM, local time
+	Percent float64 `json:"percent"`
+
+	from, to int // minutes after midnight
+}
+
+// ThrottleConfig limits the load an export puts on the database. Limits of 0 aren't enforced.
+// The schedule scales both limits, with the first matching window used, and Percent outside of any window.
+type ThrottleConfig struct {
+	QPS      float64          `json:"qps"`      // most queries started per second
+	DBTime   float64          `json:"db_time"`  // most seconds of query time per second, summed over all queries
+	Schedule []ThrottleWindow `json:"schedule"` // percent of the limits applied during times of day
+	Percent  *float64         `json:"percent"`  // percent of the limits outside of the windows, 100 if not set
+}
+
+// Enabled reports whether the config limits anything
+func (c ThrottleConfig) Enabled() bool {
+	return c.QPS > 0 || c.DBTime > 0
+}
+
+// LoadThrottleConfig reads a throttle config from a json file
+func LoadThrottleConfig(filename string) (ThrottleConfig, error) {
+	var c ThrottleConfig
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("error parsing throttle config %s: %w", filename, err)
+	}
+	return c, nil
+}
+
+// ParseThrottleSchedule parses a comma-delimited schedule of HH:MM-HH:MM=percent windows, with an optional
+// default=percent entry for the rest of the day (eg: 22:00-06:00=100,default=20)
+func ParseThrottleSchedule(s string) (windows []ThrottleWindow, percent *float64, err error) {
+	for _, entry := range strings.Split(s, ",") {
+		span, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid throttle schedule (%s), expected HH:MM-HH:MM=percent or default=percent", entry)
+		}
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid throttle schedule (%s): %w", entry, err)
+		}
+		if span == "default" {
+			percent = &p
+			continue
+		}
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid throttle schedule (%s), expected HH:MM-HH:MM=percent or default=percent", entry)
+		}
+		windows = append(windows, ThrottleWindow{From: from, To: to, Percent: p})
+	}
+	return windows, percent, nil
+}
+
+// parseTimeOfDay returns the minutes after midnight of a HH:MM time
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validPercent(p float64) bool {
+	return p >= 0 && p <= 100
+}
+
+// ThrottleStatus is the state of the throttle, for the progress output
+type ThrottleStatus struct {
+	Percent float64 `json:"percent"`           // percent of the limits applied now
+	QPS     float64 `json:"qps,omitempty"`     // queries per second allowed now
+	DBTime  float64 `json:"db_time,omitempty"` // seconds of query time per second allowed now
+	Waited  float64 `json:"waited_seconds"`    // total time any query waited for the throttle
+}
+
+// Throttle delays queries to keep the database load within the limits of a ThrottleConfig.
+// Queries per second are spaced evenly, and query time is a budget that refills at DBTime seconds per second.
+// It is safe for concurrent use.
+type Throttle struct {
+	mu     sync.Mutex
+	config ThrottleConfig
+	now    func() time.Time
+
+	nextQuery  time.Time // earliest time the next query may start
+	dbBudget   float64   // seconds of query time available
+	lastRefill time.Time
+	waiting    int           // callers waiting now
+	waitStart  time.Time     // when waiting went from 0 to 1
+	waited     time.Duration // time with at least one caller waiting, before waitStart
+}
+
+// NewThrottle validates the config and creates a Throttle
+func NewThrottle(c ThrottleConfig) (*Throttle, error) {
+	if c.QPS < 0 || c.DBTime < 0 {
+		return nil, fmt.Errorf("throttle limits can't be negative")
+	}
+	if c.Percent != nil && !validPercent(*c.Percent) {
+		return nil, fmt.Errorf("invalid throttle percent %g, expected 0-100", *c.Percent)
+	}
+	windows := make([]ThrottleWindow, len(c.Schedule))
+	for i, w := range c.Schedule {
+		var err error
+		if w.from, err = parseTimeOfDay(w.From); err != nil {
+			return nil, err
+		}
+		if w.to, err = parseTimeOfDay(w.To); err != nil {
+			return nil, err
+		}
+		if !validPercent(w.Percent) {
+			return nil, fmt.Errorf("invalid throttle percent %g for %s-%s, expected 0-100", w.Percent, w.From, w.To)
+		}
+		windows[i] = w
+	}
+	c.Schedule = windows
+	t := &Throttle{config: c, now: time.Now}
+	t.lastRefill = t.now()
+	t.dbBudget = c.DBTime
+	return t, nil
+}
+
+// percent returns the percent of the limits that applies at now
+func (t *Throttle) percent(now time.Time) float64 {
+	minute := now.Hour()*60 + now.Minute()
+	for _, w := range t.config.Schedule {
+		inside := minute >= w.from && minute < w.to
+		if w.to <= w.from {
+			inside = minute >= w.from || minute < w.to
+		}
+		if inside {
+			return w.Percent
+		}
+	}
+	if t.config.Percent != nil {
+		return *t.config.Percent
+	}
+	return 100
+}
+
+// reserve returns how long to wait before a query may start at now, or 0 if it may start now,
+// in which case the query counts toward the limits
+func (t *Throttle) reserve(now time.Time) time.Duration {
+	delay := t.delay(now)
+	if delay == 0 && t.config.QPS > 0 {
+		t.nextQuery = now.Add(time.Duration(float64(time.Second) / (t.config.QPS * t.percent(now) / 100)))
+	}
+	return delay
+}
+
+// delay returns how long to wait before a query may start at now, or 0 if it may start now
+func (t *Throttle) delay(now time.Time) time.Duration {
+	scale := t.percent(now) / 100
+	if scale == 0 {
+		return throttlePausePoll
+	}
+	if t.config.DBTime > 0 {
+		rate := t.config.DBTime * scale
+		t.dbBudget += now.Sub(t.lastRefill).Seconds() * rate
+		if t.dbBudget > rate {
+			// never bank more than a second of query time
+			t.dbBudget = rate
+		}
+		t.lastRefill = now
+		if t.dbBudget < 0 {
+			return time.Duration(-t.dbBudget / rate * float64(time.Second))
+		}
+	}
+	if t.config.QPS > 0 && now.Before(t.nextQuery) {
+		return t.nextQuery.Sub(now)
+	}
+	return 0
+}
+
+// Wait blocks until a query may start or ctx is done
+func (t *Throttle) Wait(ctx context.Context) error {
+	return t.wait(ctx, t.reserve)
+}
+
+// Delay returns how long a query would wait for the throttle now, without starting one
+func (t *Throttle) Delay() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.delay(t.now())
+}
+
+// Ready blocks until a query could start or ctx is done, without starting one, so that callers
+// don't hold a database connection while the throttle holds them back
+func (t *Throttle) Ready(ctx context.Context) error {
+	return t.wait(ctx, t.delay)
+}
+
+// wait sleeps until next returns 0, counting the time spent waiting once however many callers wait
+func (t *Throttle) wait(ctx context.Context, next func(now time.Time) time.Duration) error {
+	waiting := false
+	defer func() {
+		if waiting {
+			t.mu.Lock()
+			t.waiting--
+			if t.waiting == 0 {
+				t.waited += t.now().Sub(t.waitStart)
+			}
+			t.mu.Unlock()
+		}
+	}()
+	for {
+		t.mu.Lock()
+		now := t.now()
+		delay := next(now)
+		if delay > 0 && !waiting {
+			waiting = true
+			if t.waiting == 0 {
+				t.waitStart = now
+			}
+			t.waiting++
+		}
+		t.mu.Unlock()
+		if delay == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Done records the time a query took, which is taken from the query time budget
+func (t *Throttle) Done(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.config.DBTime > 0 {
+		t.dbBudget -= d.Seconds()
+	}
+}
+
+// Status returns the limits that apply now and the time spent waiting so far
+func (t *Throttle) Status() ThrottleStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	scale := t.percent(now) / 100
+	waited := t.waited
+	if t.waiting > 0 {
+		waited += now.Sub(t.waitStart)
+	}
+	return ThrottleStatus{
+		Percent: scale * 100,
+		QPS:     t.config.QPS * scale,
+		DBTime:  t.config.DBTime * scale,
+		Waited:  waited.Seconds(),
+	}
+}
@@ -0,0 +1,135 @@
+package tileutils
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseThrottleSchedule(t *testing.T) {
+	windows, percent, err := ParseThrottleSchedule("22:00-06:00=100,12:00-13:00=50,default=20")
+	require.Nil(t, err)
+	assert.Equal(t, []ThrottleWindow{{From: "22:00", To: "06:00", Percent: 100}, {From: "12:00", To: "13:00", Percent: 50}}, windows)
+	require.NotNil(t, percent)
+	assert.Equal(t, 20.0, *percent)
+
+	for _, s := range []string{"22:00-06:00", "22:00=100", "22:00-06:00=x"} {
+		_, _, err := ParseThrottleSchedule(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestNewThrottleErrors(t *testing.T) {
+	over := 120.0
+	for _, c := range []ThrottleConfig{
+		{QPS: -1},
+		{QPS: 1, Percent: &over},
+		{QPS: 1, Schedule: []ThrottleWindow{{From: "25:00", To: "06:00", Percent: 100}}},
+		{QPS: 1, Schedule: []ThrottleWindow{{From: "22:00", To: "06:00", Percent: -5}}},
+	} {
+		_, err := NewThrottle(c)
+		assert.NotNil(t, err, c)
+	}
+}
+
+func TestThrottlePercent(t *testing.T) {
+	windows, percent, err := ParseThrottleSchedule("22:00-06:00=100,default=20")
+	require.Nil(t, err)
+	th, err := NewThrottle(ThrottleConfig{QPS: 10, Schedule: windows, Percent: percent})
+	require.Nil(t, err)
+	at := func(hour, minute int) time.Time { return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local) }
+	assert.Equal(t, 100.0, th.percent(at(23, 0)))
+	assert.Equal(t, 100.0, th.percent(at(5, 59)))
+	assert.Equal(t, 20.0, th.percent(at(6, 0)))
+	assert.Equal(t, 20.0, th.percent(at(14, 30)))
+
+	th.now = func() time.Time { return at(14, 30) }
+	assert.Equal(t, ThrottleStatus{Percent: 20, QPS: 2}, th.Status())
+}
+
+func TestThrottleQPS(t *testing.T) {
+	th, err := NewThrottle(ThrottleConfig{QPS: 4})
+	require.Nil(t, err)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	assert.Equal(t, time.Duration(0), th.reserve(now))
+	// queries are spaced a quarter second apart
+	assert.Equal(t, 250*time.Millisecond, th.reserve(now))
+	assert.Equal(t, 150*time.Millisecond, th.reserve(now.Add(100*time.Millisecond)))
+	assert.Equal(t, time.Duration(0), th.reserve(now.Add(250*time.Millisecond)))
+}
+
+func TestThrottleReady(t *testing.T) {
+	th, err := NewThrottle(ThrottleConfig{QPS: 4})
+	require.Nil(t, err)
+	now := time.Now()
+	// checking doesn't use up the query
+	assert.Equal(t, time.Duration(0), th.delay(now))
+	assert.Equal(t, time.Duration(0), th.reserve(now))
+	assert.Equal(t, 250*time.Millisecond, th.delay(now))
+	assert.Equal(t, 250*time.Millisecond, th.reserve(now))
+}
+
+func TestThrottleWaited(t *testing.T) {
+	th, err := NewThrottle(ThrottleConfig{QPS: 10})
+	require.Nil(t, err)
+	require.Nil(t, th.Wait(context.Background()))
+	// two queries waiting at once for 100ms and 200ms only count the 200ms
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, th.Wait(context.Background()))
+		}()
+	}
+	wg.Wait()
+	waited := th.Status().Waited
+	assert.GreaterOrEqual(t, waited, 0.15)
+	assert.Less(t, waited, 0.28)
+}
+
+func TestThrottleDBTime(t *testing.T) {
+	th, err := NewThrottle(ThrottleConfig{DBTime: 2})
+	require.Nil(t, err)
+	now := th.lastRefill
+	assert.Equal(t, time.Duration(0), th.reserve(now))
+	// a 5s query uses the 2s budget and 3s more, which takes 1.5s to refill at 2s per second
+	th.Done(5 * time.Second)
+	assert.Equal(t, 1500*time.Millisecond, th.reserve(now))
+	// which is waited out holding the connection
+	assert.Less(t, th.delay(now), ThrottleReleaseDelay)
+	assert.Equal(t, 500*time.Millisecond, th.reserve(now.Add(time.Second)))
+	assert.Equal(t, time.Duration(0), th.reserve(now.Add(1500*time.Millisecond)))
+}
+
+func TestThrottlePaused(t *testing.T) {
+	paused := 0.0
+	th, err := NewThrottle(ThrottleConfig{QPS: 100, Percent: &paused})
+	require.Nil(t, err)
+	assert.Equal(t, throttlePausePoll, th.reserve(time.Now()))
+	// a pause is long enough for workers to give back their connections
+	assert.GreaterOrEqual(t, th.Delay(), ThrottleReleaseDelay)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, th.Wait(ctx), context.Canceled)
+}
+
+func TestLoadThrottleConfig(t *testing.T) {
+	filename := path.Join(t.TempDir(), "throttle.json")
+	require.Nil(t, os.WriteFile(filename, []byte(`{"qps": 50, "db_time": 8, "schedule": [{"from": "22:00", "to": "06:00", "percent": 100}], "percent": 20}`), 0644))
+	c, err := LoadThrottleConfig(filename)
+	require.Nil(t, err)
+	assert.Equal(t, 50.0, c.QPS)
+	assert.Equal(t, 8.0, c.DBTime)
+	assert.Equal(t, []ThrottleWindow{{From: "22:00", To: "06:00", Percent: 100}}, c.Schedule)
+	require.NotNil(t, c.Percent)
+	assert.Equal(t, 20.0, *c.Percent)
+	assert.True(t, c.Enabled())
+}
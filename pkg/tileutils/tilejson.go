@@ -2,26 +2,55 @@ package tileutils
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 )
 
 type TileJSON struct {
-	Attribution  string        `json:"attribution,omitempty"`
-	Name         string        `json:"name"`
-	Description  string        `json:"description"`
-	Version      string        `json:"version,omitempty"`
-	MinZoom      int           `json:"minzoom"`
-	MaxZoom      int           `json:"maxzoom"`
-	Bounds       []float64     `json:"bounds,omitempty"`
-	Center       []float64     `json:"center,omitempty"`
-	VectorLayers []VectorLayer `json:"vector_layers"`
+	Attribution     string            `json:"attribution,omitempty"`
+	Name            string            `json:"name"`
+	Description     string            `json:"description"`
+	Version         string            `json:"version,omitempty"`
+	MinZoom         int               `json:"minzoom"`
+	MaxZoom         int               `json:"maxzoom"`
+	Bounds          []float64         `json:"bounds,omitempty"`
+	Center          []float64         `json:"center,omitempty"`
+	ZoomBounds      []ZoomBounds      `json:"zoom_bounds,omitempty"`
+	ZoomConcurrency []ZoomConcurrency `json:"zoom_concurrency,omitempty"`
+	VectorLayers    []VectorLayer     `json:"vector_layers"`
+
+	Extra map[string]json.RawMessage `json:"-"` // keys that aren't modeled above, kept as they were in the file
+}
+
+// ZoomBounds overrides the TileJSON bounds for a range of zooms.
+// Unlike the layer queries, MaxZoom is inclusive.
+type ZoomBounds struct {
+	MinZoom int       `json:"minzoom"`
+	MaxZoom int       `json:"maxzoom"`
+	Bounds  []float64 `json:"bounds"`
 }
 
 type VectorLayer struct {
-	ID      string        `json:"id"`
-	Queries []VectorQuery `json:"queries"`
+	ID          string        `json:"id"`
+	Description string        `json:"description,omitempty"`
+	Queries     []VectorQuery `json:"queries"`
+
+	Extra map[string]json.RawMessage `json:"-"` // keys that aren't modeled above, kept as they were in the file
+}
+
+// UnmarshalJSON reads the layer, and keeps the keys it doesn't model in Extra
+func (l *VectorLayer) UnmarshalJSON(data []byte) error {
+	type vectorLayer VectorLayer
+	if err := json.Unmarshal(data, (*vectorLayer)(l)); err != nil {
+		return err
+	}
+	var err error
+	l.Extra, err = unknownFields(data, *l)
+	return err
 }
 
 type VectorQuery struct {
@@ -30,6 +59,93 @@ type VectorQuery struct {
 	SQL     string `json:"sql"`
 }
 
+// worldBoundingBox covers the whole web mercator world
+var worldBoundingBox = BoundingBox{
+	Left:   -180,
+	Right:  180,
+	Bottom: -85.0511,
+	Top:    85.0511,
+}
+
+// boundsToBoundingBox converts [left, bottom, right, top] bounds to a BoundingBox
+func boundsToBoundingBox(bounds []float64) BoundingBox {
+	return BoundingBox{
+		Left:   bounds[0],
+		Right:  bounds[2],
+		Bottom: bounds[1],
+		Top:    bounds[3],
+	}
+}
+
+// UnionBounds returns the [left, bottom, right, top] bounds covering the TileJSON bounds and all of its zoom bounds.
+// It returns nil if no bounds are set.
+func (tj *TileJSON) UnionBounds() []float64 {
+	var union []float64
+	add := func(bounds []float64) {
+		if len(bounds) != 4 {
+			return
+		}
+		if union == nil {
+			union = append([]float64{}, bounds...)
+			return
+		}
+		union[0] = math.Min(union[0], bounds[0])
+		union[1] = math.Min(union[1], bounds[1])
+		union[2] = math.Max(union[2], bounds[2])
+		union[3] = math.Max(union[3], bounds[3])
+	}
+	add(tj.Bounds)
+	for _, zb := range tj.ZoomBounds {
+		add(zb.Bounds)
+	}
+	return union
+}
+
+// BoundingBox returns the union of the TileJSON bounds and all zoom bounds as a BoundingBox.
+// If no valid bounds are set, the whole world is returned.
+func (tj *TileJSON) BoundingBox() BoundingBox {
+	union := tj.UnionBounds()
+	if union == nil {
+		return worldBoundingBox
+	}
+	return boundsToBoundingBox(union)
+}
+
+// BoundingBoxes returns the bounding boxes to enumerate at zoom z. The zoom bounds covering z
+// take precedence over the TileJSON bounds, which are used when no zoom bounds apply.
+func (tj *TileJSON) BoundingBoxes(z int) []BoundingBox {
+	boxes := []BoundingBox{}
+	for _, zb := range tj.ZoomBounds {
+		if z >= zb.MinZoom && z <= zb.MaxZoom {
+			boxes = append(boxes, boundsToBoundingBox(zb.Bounds))
+		}
+	}
+	if len(boxes) > 0 {
+		return boxes
+	}
+	if len(tj.Bounds) != 4 {
+		return []BoundingBox{worldBoundingBox}
+	}
+	return []BoundingBox{boundsToBoundingBox(tj.Bounds)}
+}
+
+// unknownFields returns the keys of the json object in data that don't map to a field of the struct v
+func unknownFields(data []byte, v interface{}) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		delete(fields, name)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
 // ZoomLayerInfo is a mapped index of queries at each zoom.
 // map[int] where int is the zoom level.
 // map[string][]string where string1 is the layer name/id and []string is the list of queries.
@@ -50,6 +166,17 @@ func ParseTileJSON(filename string) (*TileJSON, ZoomLayerInfo, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	if tj.Extra, err = unknownFields(jsonBytes, tj); err != nil {
+		return nil, nil, err
+	}
+	for _, zb := range tj.ZoomBounds {
+		if len(zb.Bounds) != 4 {
+			return nil, nil, fmt.Errorf("invalid zoom_bounds for zooms %d-%d, expected 4 values but got %d", zb.MinZoom, zb.MaxZoom, len(zb.Bounds))
+		}
+	}
+	if err := ValidateZoomConcurrency(tj.ZoomConcurrency); err != nil {
+		return nil, nil, err
+	}
 	// iterate through vector layers to extract the relevant SQL at each layer
 	zooms := ZoomLayerInfo{}
 	for _, layer := range tj.VectorLayers {
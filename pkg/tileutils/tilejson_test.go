@@ -1,6 +1,7 @@
 package tileutils
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -21,3 +22,68 @@ func TestParseTileJSON(t *testing.T) {
 	assert.Contains(lq[12]["labels"], "SELECT id, tags, geom FROM big_labels")
 	assert.Contains(lq[12]["labels"], "SELECT id, tags, geom FROM small_labels")
 }
+
+func TestZoomBounds(t *testing.T) {
+	assert := assert.New(t)
+	tj := &TileJSON{
+		Bounds: []float64{-180, -85, 180, 85},
+		ZoomBounds: []ZoomBounds{
+			{MinZoom: 3, MaxZoom: 4, Bounds: []float64{-125, 24, -66, 50}},
+			{MinZoom: 4, MaxZoom: 4, Bounds: []float64{-160, 18, -154, 23}},
+		},
+	}
+	assert.Len(tj.BoundingBoxes(2), 1)
+	assert.Equal(BoundingBox{Left: -180, Right: 180, Bottom: -85, Top: 85}, tj.BoundingBoxes(2)[0])
+	assert.Len(tj.BoundingBoxes(3), 1)
+	assert.Equal(BoundingBox{Left: -125, Right: -66, Bottom: 24, Top: 50}, tj.BoundingBoxes(3)[0])
+	assert.Len(tj.BoundingBoxes(4), 2)
+	assert.Len(tj.BoundingBoxes(5), 1)
+
+	// the union always covers the global bounds
+	assert.Equal([]float64{-180, -85, 180, 85}, tj.UnionBounds())
+	tj.Bounds = nil
+	assert.Equal([]float64{-160, 18, -66, 50}, tj.UnionBounds())
+
+	tiles := ListTiles([]int{2, 3, 4}, &TileJSON{
+		Bounds:     []float64{-180, -85, 180, 85},
+		ZoomBounds: tj.ZoomBounds,
+	})
+	counts := map[int]int{}
+	for _, tc := range tiles {
+		counts[tc.Z]++
+	}
+	assert.Equal(16, counts[2])
+	assert.Equal(4, counts[3])
+	assert.Equal(12, counts[4])
+}
+
+func TestTileJSONExtra(t *testing.T) {
+	assert := assert.New(t)
+	tj, _, err := ParseTileJSON("./testdata/tiles.json")
+	assert.Nil(err)
+	assert.Len(tj.Extra, 5)
+	assert.JSONEq(`12`, string(tj.Extra["fillzoom"]))
+	assert.JSONEq(`{"owner": "maps"}`, string(tj.Extra["custom"]))
+	assert.Contains(tj.Extra, "tiles")
+	assert.Equal("oceans and seas", tj.VectorLayers[0].Description)
+	assert.Equal(map[string]json.RawMessage{"group": json.RawMessage(`"water"`)}, tj.VectorLayers[0].Extra)
+	assert.Nil(tj.VectorLayers[1].Extra)
+
+	meta := CreateMetadata(tj, CreateMetadataOptions{
+		Format:    MbTilesFormatPbf,
+		Overrides: map[string]string{"type": "overlay", "generator": "baremaps-exporter"},
+	})
+	assert.Equal("12", meta["fillzoom"])
+	assert.Equal("roads and oceans", meta["legend"])
+	assert.Equal(`{"owner": "maps"}`, meta["custom"])
+	assert.Equal("overlay", meta["type"])
+	assert.Equal("baremaps-exporter", meta["generator"])
+	assert.NotContains(meta, "tiles")
+	assert.NotContains(meta, "tilejson")
+	assert.Contains(meta["json"], `"description":"oceans and seas"`)
+	assert.Contains(meta["json"], `"group":"water"`)
+
+	// without overrides the type is still a baselayer
+	meta = CreateMetadata(tj, CreateMetadataOptions{})
+	assert.Equal("baselayer", meta["type"])
+}
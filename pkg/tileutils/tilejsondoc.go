@@ -0,0 +1,258 @@
+package tileutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// TileJSONSpecVersion is the version of the TileJSON spec the exported documents follow
+const TileJSONSpecVersion = "3.0.0"
+
+// TileJSONDocument is the public TileJSON document written next to an export, for tile servers and clients.
+// Unlike TileJSON it has the tile URLs, and it never has the layer queries.
+type TileJSONDocument struct {
+	TileJSON     string                `json:"tilejson"`
+	Name         string                `json:"name,omitempty"`
+	Description  string                `json:"description,omitempty"`
+	Version      string                `json:"version,omitempty"`
+	Attribution  string                `json:"attribution,omitempty"`
+	Scheme       string                `json:"scheme"`
+	Tiles        []string              `json:"tiles"`
+	MinZoom      int                   `json:"minzoom"`
+	MaxZoom      int                   `json:"maxzoom"`
+	Bounds       []float64             `json:"bounds,omitempty"`
+	Center       []float64             `json:"center,omitempty"`
+	VectorLayers []MetadataVectorLayer `json:"vector_layers"`
+
+	Extra map[string]json.RawMessage `json:"-"` // other public keys from the input TileJSON, written unless the document sets them
+}
+
+// MarshalJSON writes the document fields along with the extra keys
+func (doc TileJSONDocument) MarshalJSON() ([]byte, error) {
+	type document TileJSONDocument
+	data, err := json.Marshal(document(doc))
+	if err != nil {
+		return nil, err
+	}
+	return addExtraFields(data, doc.Extra)
+}
+
+// publicTileJSONKeys are the keys of the TileJSON spec that the document doesn't model.
+// Other keys of the input are only published when they are listed in the PublicKeys option,
+// since they may be private settings.
+var publicTileJSONKeys = map[string]bool{
+	"fillzoom": true,
+	"legend":   true,
+	"template": true,
+	"grids":    true,
+	"data":     true,
+}
+
+// publicExtra returns the extra keys which are part of the TileJSON spec or listed in publicKeys
+func publicExtra(extra map[string]json.RawMessage, publicKeys []string) map[string]json.RawMessage {
+	public := map[string]json.RawMessage{}
+	for k, v := range extra {
+		if publicTileJSONKeys[k] {
+			public[k] = v
+		}
+	}
+	for _, k := range publicKeys {
+		if v, ok := extra[k]; ok {
+			public[k] = v
+		}
+	}
+	if len(public) == 0 {
+		return nil
+	}
+	return public
+}
+
+// TileJSONDocumentOptions are the details of the export that aren't in the input TileJSON
+type TileJSONDocumentOptions struct {
+	BaseURL    string                       // URL the tiles are served from, the tiles template is BaseURL/{z}/{x}/{y}.mvt
+	Version    string                       // overrides the TileJSON version if set
+	Fields     map[string]map[string]string // attribute names and types of each layer, see LayerCollector
+	PublicKeys []string                     // keys of the input TileJSON to publish besides the ones of the TileJSON spec
+}
+
+// CreateTileJSONDocument builds the public TileJSON document of an export from the input TileJSON
+func CreateTileJSONDocument(tj *TileJSON, opts TileJSONDocumentOptions) *TileJSONDocument {
+	doc := &TileJSONDocument{
+		TileJSON:     TileJSONSpecVersion,
+		Name:         tj.Name,
+		Description:  tj.Description,
+		Version:      tj.Version,
+		Attribution:  tj.Attribution,
+		Scheme:       "xyz",
+		Tiles:        []string{strings.TrimSuffix(opts.BaseURL, "/") + "/{z}/{x}/{y}.mvt"},
+		MinZoom:      tj.MinZoom,
+		MaxZoom:      tj.MaxZoom,
+		Bounds:       tj.UnionBounds(),
+		Center:       tj.Center,
+		VectorLayers: extractLayersFromTileJSON(tj, opts.Fields),
+		Extra:        publicExtra(tj.Extra, opts.PublicKeys),
+	}
+	if opts.Version != "" {
+		doc.Version = opts.Version
+	}
+	if doc.MinZoom == -1 {
+		doc.MinZoom = 0
+	}
+	if doc.MaxZoom == -1 {
+		doc.MaxZoom = 22
+	}
+	return doc
+}
+
+// metadataDocumentKeys are the mbtiles metadata keys that aren't extra keys of a TileJSON document:
+// the ones the document models, and the ones that only belong in mbtiles
+var metadataDocumentKeys = map[string]bool{
+	"name":        true,
+	"description": true,
+	"version":     true,
+	"attribution": true,
+	"minzoom":     true,
+	"maxzoom":     true,
+	"bounds":      true,
+	"center":      true,
+	"format":      true,
+	"type":        true,
+	"json":        true,
+}
+
+// tileJSONValue converts a metadata value back to a raw json value, the reverse of metadataValue
+func tileJSONValue(value string) json.RawMessage {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err == nil {
+		if _, ok := v.(string); !ok {
+			return json.RawMessage(value)
+		}
+	}
+	raw, _ := json.Marshal(value)
+	return raw
+}
+
+// TileJSONDocumentFromMetadata builds the public TileJSON document of a mbtiles file from its metadata,
+// with the tiles served from baseURL. Metadata keys the document doesn't model are kept as extra keys
+// if they are part of the TileJSON spec or listed in publicKeys.
+func TileJSONDocumentFromMetadata(meta MbTilesMetadata, baseURL string, publicKeys []string) (*TileJSONDocument, error) {
+	doc := &TileJSONDocument{
+		TileJSON:     TileJSONSpecVersion,
+		Name:         meta["name"],
+		Description:  meta["description"],
+		Version:      meta["version"],
+		Attribution:  meta["attribution"],
+		Scheme:       "xyz",
+		Tiles:        []string{strings.TrimSuffix(baseURL, "/") + "/{z}/{x}/{y}.mvt"},
+		MaxZoom:      22,
+		VectorLayers: []MetadataVectorLayer{},
+	}
+	var err error
+	if v, ok := meta["minzoom"]; ok {
+		if doc.MinZoom, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid minzoom in metadata: %w", err)
+		}
+	}
+	if v, ok := meta["maxzoom"]; ok {
+		if doc.MaxZoom, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid maxzoom in metadata: %w", err)
+		}
+	}
+	if v, ok := meta["bounds"]; ok {
+		if doc.Bounds, err = parseFloats(v); err != nil || len(doc.Bounds) != 4 {
+			return nil, fmt.Errorf("invalid bounds in metadata: %s", v)
+		}
+	}
+	if v, ok := meta["center"]; ok && v != "" {
+		if doc.Center, err = parseFloats(v); err != nil || len(doc.Center) < 2 || len(doc.Center) > 3 {
+			return nil, fmt.Errorf("invalid center in metadata: %s", v)
+		}
+	}
+	if v, ok := meta["json"]; ok {
+		var metaJSON MetadataJSON
+		if err := json.Unmarshal([]byte(v), &metaJSON); err != nil {
+			return nil, fmt.Errorf("invalid json in metadata: %w", err)
+		}
+		if metaJSON.VectorLayers != nil {
+			doc.VectorLayers = metaJSON.VectorLayers
+		}
+	}
+	extra := map[string]json.RawMessage{}
+	for k, v := range meta {
+		if !metadataDocumentKeys[k] {
+			extra[k] = tileJSONValue(v)
+		}
+	}
+	doc.Extra = publicExtra(extra, publicKeys)
+	return doc, nil
+}
+
+// ReadTileJSONDocument reads a TileJSON document, such as the one written next to a directory output
+func ReadTileJSONDocument(filename string) (*TileJSONDocument, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	doc := &TileJSONDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("error parsing tilejson %s: %w", filename, err)
+	}
+	if doc.Extra, err = unknownFields(data, *doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// TileJSONDocumentPath returns where the TileJSON document of an output goes:
+// tiles.json inside a directory output, or the archive filename with a .json extension
+func TileJSONDocumentPath(output string, directory bool) string {
+	if directory {
+		return filepath.Join(output, "tiles.json")
+	}
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".json"
+}
+
+// checkTileJSONDocument returns an error if filename exists and isn't a TileJSON document
+// written by an export, such as an unrelated json file or a tilejson with layer queries
+func checkTileJSONDocument(filename string) error {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var doc struct {
+		TileJSON     string `json:"tilejson"`
+		VectorLayers []struct {
+			Queries json.RawMessage `json:"queries"`
+		} `json:"vector_layers"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil || doc.TileJSON == "" {
+		return fmt.Errorf("not overwriting %s, it isn't a tilejson document", filename)
+	}
+	for _, layer := range doc.VectorLayers {
+		if len(layer.Queries) > 0 {
+			return fmt.Errorf("not overwriting %s, it is a tilejson with layer queries", filename)
+		}
+	}
+	return nil
+}
+
+// WriteTileJSONDocument writes the document to filename as indented json.
+// An existing file is only replaced if it is a TileJSON document written by an export.
+func WriteTileJSONDocument(filename string, doc *TileJSONDocument) error {
+	if err := checkTileJSONDocument(filename); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
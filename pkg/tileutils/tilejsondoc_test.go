@@ -0,0 +1,136 @@
+package tileutils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTileJSONDocument(t *testing.T) {
+	tj, _, err := ParseTileJSON("./testdata/tiles.json")
+	require.Nil(t, err)
+	doc := CreateTileJSONDocument(tj, TileJSONDocumentOptions{
+		BaseURL: "https://tiles.example.com/base/",
+		Version: "2.0.0",
+		Fields:  map[string]map[string]string{"ocean": {"id": FieldTypeNumber}},
+	})
+	assert.Equal(t, "3.0.0", doc.TileJSON)
+	assert.Equal(t, []string{"https://tiles.example.com/base/{z}/{x}/{y}.mvt"}, doc.Tiles)
+	assert.Equal(t, "xyz", doc.Scheme)
+	assert.Equal(t, "2.0.0", doc.Version)
+	assert.Equal(t, "for me", doc.Attribution)
+	assert.Equal(t, 0, doc.MinZoom)
+	assert.Equal(t, 14, doc.MaxZoom)
+	require.Len(t, doc.VectorLayers, len(tj.VectorLayers))
+	assert.Equal(t, "ocean", *doc.VectorLayers[0].ID)
+	assert.Equal(t, map[string]string{"id": FieldTypeNumber}, doc.VectorLayers[0].Fields)
+
+	filename := filepath.Join(t.TempDir(), "tiles.json")
+	require.Nil(t, WriteTileJSONDocument(filename, doc))
+	data, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	// the queries must never be published
+	assert.NotContains(t, string(data), "queries")
+	assert.NotContains(t, string(data), "SELECT")
+	// other keys of the TileJSON spec are kept, but not the ones the document sets, or custom keys
+	var written map[string]interface{}
+	require.Nil(t, json.Unmarshal(data, &written))
+	assert.Equal(t, 12.0, written["fillzoom"])
+	assert.NotContains(t, written, "custom")
+	assert.Equal(t, "3.0.0", written["tilejson"])
+	assert.Equal(t, []interface{}{"https://tiles.example.com/base/{z}/{x}/{y}.mvt"}, written["tiles"])
+	layer := written["vector_layers"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "oceans and seas", layer["description"])
+	assert.Equal(t, "water", layer["group"])
+
+	// custom keys are only published when asked for
+	doc = CreateTileJSONDocument(tj, TileJSONDocumentOptions{PublicKeys: []string{"custom", "unset"}})
+	assert.Equal(t, map[string]json.RawMessage{
+		"fillzoom": json.RawMessage("12"),
+		"legend":   json.RawMessage(`"roads and oceans"`),
+		"custom":   json.RawMessage(`{"owner": "maps"}`),
+	}, doc.Extra)
+}
+
+func TestWriteTileJSONDocumentOverwrite(t *testing.T) {
+	tj, _, err := ParseTileJSON("./testdata/tiles.json")
+	require.Nil(t, err)
+	doc := CreateTileJSONDocument(tj, TileJSONDocumentOptions{})
+	dir := t.TempDir()
+
+	// the document of a previous export is replaced
+	filename := filepath.Join(dir, "world.json")
+	require.Nil(t, WriteTileJSONDocument(filename, doc))
+	require.Nil(t, WriteTileJSONDocument(filename, doc))
+
+	// but not unrelated files, or a tilejson with queries
+	other := filepath.Join(dir, "other.json")
+	require.Nil(t, os.WriteFile(other, []byte(`{"settings": true}`), 0644))
+	assert.ErrorContains(t, WriteTileJSONDocument(other, doc), "isn't a tilejson document")
+	data, err := os.ReadFile(other)
+	require.Nil(t, err)
+	assert.Equal(t, `{"settings": true}`, string(data))
+	input, err := os.ReadFile("./testdata/tiles.json")
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(other, input, 0644))
+	assert.ErrorContains(t, WriteTileJSONDocument(other, doc), "layer queries")
+}
+
+func TestTileJSONDocumentPath(t *testing.T) {
+	assert.Equal(t, "out/tiles.json", TileJSONDocumentPath("out", true))
+	assert.Equal(t, "out/world.json", TileJSONDocumentPath("out/world.mbtiles", false))
+}
+
+func TestTileJSONDocumentFromMetadata(t *testing.T) {
+	tj, _, err := ParseTileJSON("./testdata/tiles.json")
+	require.Nil(t, err)
+	meta := CreateMetadata(tj, CreateMetadataOptions{
+		Format: MbTilesFormatPbf,
+		Fields: map[string]map[string]string{"ocean": {"id": FieldTypeNumber}},
+	})
+	doc, err := TileJSONDocumentFromMetadata(meta, "https://tiles.example.com/", []string{"custom"})
+	require.Nil(t, err)
+	// the document matches the one written next to the export
+	expected := CreateTileJSONDocument(tj, TileJSONDocumentOptions{
+		BaseURL:    "https://tiles.example.com",
+		Fields:     map[string]map[string]string{"ocean": {"id": FieldTypeNumber}},
+		PublicKeys: []string{"custom"},
+	})
+	expectedJSON, err := json.Marshal(expected)
+	require.Nil(t, err)
+	docJSON, err := json.Marshal(doc)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(expectedJSON), string(docJSON))
+
+	// metadata keys outside of the TileJSON spec aren't published by default
+	doc, err = TileJSONDocumentFromMetadata(meta, "", nil)
+	require.Nil(t, err)
+	assert.NotContains(t, doc.Extra, "custom")
+	assert.Contains(t, doc.Extra, "fillzoom")
+
+	_, err = TileJSONDocumentFromMetadata(MbTilesMetadata{"bounds": "1,2"}, "", nil)
+	assert.NotNil(t, err)
+
+	// without metadata, the document still has the required keys
+	doc, err = TileJSONDocumentFromMetadata(MbTilesMetadata{}, "", nil)
+	require.Nil(t, err)
+	assert.Equal(t, 22, doc.MaxZoom)
+	assert.NotNil(t, doc.VectorLayers)
+}
+
+func TestReadTileJSONDocument(t *testing.T) {
+	tj, _, err := ParseTileJSON("./testdata/tiles.json")
+	require.Nil(t, err)
+	doc := CreateTileJSONDocument(tj, TileJSONDocumentOptions{BaseURL: "http://localhost:8080"})
+	filename := filepath.Join(t.TempDir(), "tiles.json")
+	require.Nil(t, WriteTileJSONDocument(filename, doc))
+	read, err := ReadTileJSONDocument(filename)
+	require.Nil(t, err)
+	assert.Equal(t, doc.Tiles, read.Tiles)
+	assert.Equal(t, doc.VectorLayers, read.VectorLayers)
+	assert.Equal(t, json.RawMessage("12"), read.Extra["fillzoom"])
+}
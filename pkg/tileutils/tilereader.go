@@ -0,0 +1,96 @@
+package tileutils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// ErrTileNotFound is returned by a TileReader for tiles that aren't in the output
+var ErrTileNotFound = errors.New("tile not found")
+
+// TileReader reads the tiles of an export back, for serving them
+type TileReader interface {
+	// Read returns the stored data of a tile, which may be gzipped, and when it was last modified
+	Read(tc TileCoords) ([]byte, time.Time, error)
+	Close() error
+}
+
+// MbTilesReader reads the tiles of a mbtiles file, opened read-only
+type MbTilesReader struct {
+	Filename string
+	db       *sql.DB
+	stmt     *sql.Stmt
+	modTime  time.Time
+}
+
+// OpenMbTilesReader opens a mbtiles file for reading its tiles
+func OpenMbTilesReader(filename string) (*MbTilesReader, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+filename+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := db.Prepare("SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error reading tiles from %s: %w", filename, err)
+	}
+	return &MbTilesReader{Filename: filename, db: db, stmt: stmt, modTime: info.ModTime()}, nil
+}
+
+// Read returns a tile, converting its XYZ row to the TMS row of the mbtiles.
+// Every tile has the modification time of the file.
+func (r *MbTilesReader) Read(tc TileCoords) ([]byte, time.Time, error) {
+	var data []byte
+	err := r.stmt.QueryRow(tc.Z, tc.X, 1<<tc.Z-tc.Y-1).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, ErrTileNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, r.modTime, nil
+}
+
+// Metadata reads the metadata table of the mbtiles
+func (r *MbTilesReader) Metadata() (MbTilesMetadata, error) {
+	return ReadMbTilesMetadata(r.Filename)
+}
+
+func (r *MbTilesReader) Close() error {
+	r.stmt.Close()
+	return r.db.Close()
+}
+
+// DirectoryReader reads the tiles of a directory written by FileWriter, like Path/{z}/{x}/{y}.mvt
+type DirectoryReader struct {
+	Path string
+}
+
+func (r *DirectoryReader) Read(tc TileCoords) ([]byte, time.Time, error) {
+	filename := path.Join(r.Path, strconv.Itoa(tc.Z), strconv.Itoa(tc.X), strconv.Itoa(tc.Y)+".mvt")
+	info, err := os.Stat(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, time.Time{}, ErrTileNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, info.ModTime(), nil
+}
+
+func (r *DirectoryReader) Close() error {
+	return nil
+}
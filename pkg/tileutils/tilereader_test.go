@@ -0,0 +1,66 @@
+package tileutils
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMbTilesReader(t *testing.T) {
+	filename := path.Join(t.TempDir(), "out.mbtiles")
+	writeTestShard(t, filename, MbTilesMetadata{"name": "test"}, []TileCoords{{Z: 0}, {Z: 2, X: 1, Y: 0}})
+
+	r, err := OpenMbTilesReader(filename)
+	require.Nil(t, err)
+	defer r.Close()
+	// the row is stored flipped, as TMS
+	data, modTime, err := r.Read(TileCoords{Z: 2, X: 1, Y: 0})
+	require.Nil(t, err)
+	assert.Equal(t, []byte{1}, data)
+	assert.False(t, modTime.IsZero())
+	_, _, err = r.Read(TileCoords{Z: 2, X: 1, Y: 3})
+	assert.ErrorIs(t, err, ErrTileNotFound)
+
+	meta, err := r.Metadata()
+	require.Nil(t, err)
+	assert.Equal(t, "test", meta["name"])
+
+	_, err = OpenMbTilesReader(path.Join(t.TempDir(), "missing.mbtiles"))
+	assert.NotNil(t, err)
+}
+
+func TestMbTilesReaderEmptyTile(t *testing.T) {
+	filename := path.Join(t.TempDir(), "out.mbtiles")
+	w := &MbTilesWriter{Filename: filename}
+	_, closeWriter, err := w.New()
+	require.Nil(t, err)
+	// the canned tile of --write-empty
+	empty, err := Gzip([]byte{})
+	require.Nil(t, err)
+	require.Nil(t, w.Write(1, 0, 0, empty))
+	closeWriter()
+
+	r, err := OpenMbTilesReader(filename)
+	require.Nil(t, err)
+	defer r.Close()
+	data, _, err := r.Read(TileCoords{Z: 1, X: 0, Y: 0})
+	require.Nil(t, err)
+	assert.NotEmpty(t, data)
+	assert.True(t, IsEmptyTile(data))
+}
+
+func TestDirectoryReader(t *testing.T) {
+	dir := t.TempDir()
+	fw := &FileWriter{Path: dir}
+	require.Nil(t, fw.Write(3, 2, 1, []byte("tile")))
+
+	r := &DirectoryReader{Path: dir}
+	data, modTime, err := r.Read(TileCoords{Z: 3, X: 2, Y: 1})
+	require.Nil(t, err)
+	assert.Equal(t, []byte("tile"), data)
+	assert.False(t, modTime.IsZero())
+	_, _, err = r.Read(TileCoords{Z: 3, X: 2, Y: 2})
+	assert.ErrorIs(t, err, ErrTileNotFound)
+}
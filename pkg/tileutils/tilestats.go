@@ -0,0 +1,336 @@
+package tileutils
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/paulmach/orb/encoding/mvt/vectortile"
+)
+
+// DefaultTileStatsValues is the default number of sample values kept for each attribute
+const DefaultTileStatsValues = 100
+
+// maxTileStatsAttributes limits the number of attributes with stats in each layer, like mapbox-geostats does
+const maxTileStatsAttributes = 1000
+
+// sketchSize is the number of hashes kept to estimate the number of distinct values of an attribute
+const sketchSize = 256
+
+// TileStats is the tilestats object of the metadata json, in the format of mapbox-geostats.
+// Feature counts are counted per tile, so a feature that spans several tiles or zooms is counted in each one.
+type TileStats struct {
+	LayerCount int              `json:"layerCount"`
+	Layers     []TileStatsLayer `json:"layers"`
+}
+
+// TileStatsLayer holds the stats of a single layer
+type TileStatsLayer struct {
+	Layer          string               `json:"layer"`
+	Count          int                  `json:"count"`
+	Geometry       string               `json:"geometry,omitempty"` // the most common geometry type
+	AttributeCount int                  `json:"attributeCount"`
+	Attributes     []TileStatsAttribute `json:"attributes"`
+}
+
+// TileStatsAttribute holds the stats of a single attribute of a layer.
+// Count is the number of distinct values, which is estimated once there are more than a few hundred.
+type TileStatsAttribute struct {
+	Attribute string        `json:"attribute"`
+	Count     int           `json:"count"`
+	Type      string        `json:"type"` // string, number, boolean or mixed
+	Values    []interface{} `json:"values"`
+	Min       *float64      `json:"min,omitempty"`
+	Max       *float64      `json:"max,omitempty"`
+}
+
+// distinctSketch estimates the number of distinct values from the smallest hashes seen (k minimum values),
+// so it never holds more than sketchSize hashes
+type distinctSketch struct {
+	hashes []uint64 // sorted
+}
+
+func (s *distinctSketch) Add(h uint64) {
+	i := sort.Search(len(s.hashes), func(i int) bool { return s.hashes[i] >= h })
+	if i < len(s.hashes) && s.hashes[i] == h {
+		return
+	}
+	if len(s.hashes) == sketchSize {
+		if i == sketchSize {
+			return
+		}
+		s.hashes = s.hashes[:sketchSize-1]
+	}
+	s.hashes = append(s.hashes, 0)
+	copy(s.hashes[i+1:], s.hashes[i:])
+	s.hashes[i] = h
+}
+
+func (s *distinctSketch) Count() int {
+	if len(s.hashes) < sketchSize {
+		return len(s.hashes)
+	}
+	kth := float64(s.hashes[sketchSize-1]) / math.MaxUint64
+	return int(float64(sketchSize-1) / kth)
+}
+
+// attributeStats accumulates the stats of one attribute, with memory bounded by the sample size
+type attributeStats struct {
+	typ      string
+	distinct distinctSketch
+	values   []interface{}
+	sampled  map[string]bool
+	min      float64
+	max      float64
+	numbers  bool
+}
+
+func (a *attributeStats) add(v *vectortile.Tile_Value, sampleSize int) {
+	var value interface{}
+	var typ string
+	switch {
+	case v.StringValue != nil:
+		value, typ = v.GetStringValue(), "string"
+	case v.BoolValue != nil:
+		value, typ = v.GetBoolValue(), "boolean"
+	default:
+		n, ok := numberValue(v)
+		if !ok {
+			return
+		}
+		value, typ = n, "number"
+		if !a.numbers || n < a.min {
+			a.min = n
+		}
+		if !a.numbers || n > a.max {
+			a.max = n
+		}
+		a.numbers = true
+	}
+	if a.typ == "" {
+		a.typ = typ
+	} else if a.typ != typ {
+		a.typ = "mixed"
+	}
+	key := valueKey(value)
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	a.distinct.Add(h.Sum64())
+	if len(a.values) < sampleSize && !a.sampled[key] {
+		a.sampled[key] = true
+		a.values = append(a.values, value)
+	}
+}
+
+// valueKey identifies an attribute value, keeping values of different types apart
+func valueKey(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "s" + v
+	case bool:
+		return "b" + strconv.FormatBool(v)
+	case float64:
+		return "n" + strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return ""
+}
+
+// numberValue returns the value of a numeric tile value as a float64
+func numberValue(v *vectortile.Tile_Value) (float64, bool) {
+	switch {
+	case v.FloatValue != nil:
+		return float64(v.GetFloatValue()), true
+	case v.DoubleValue != nil:
+		return v.GetDoubleValue(), true
+	case v.IntValue != nil:
+		return float64(v.GetIntValue()), true
+	case v.UintValue != nil:
+		return float64(v.GetUintValue()), true
+	case v.SintValue != nil:
+		return float64(v.GetSintValue()), true
+	}
+	return 0, false
+}
+
+// layerStats accumulates the stats of one layer
+type layerStats struct {
+	count      int
+	geometries map[vectortile.Tile_GeomType]int
+	attributes map[string]*attributeStats
+}
+
+func newLayerStats() *layerStats {
+	return &layerStats{
+		geometries: map[vectortile.Tile_GeomType]int{},
+		attributes: map[string]*attributeStats{},
+	}
+}
+
+func (l *layerStats) attribute(key string) *attributeStats {
+	a, ok := l.attributes[key]
+	if !ok && len(l.attributes) < maxTileStatsAttributes {
+		a = &attributeStats{sampled: map[string]bool{}}
+		l.attributes[key] = a
+	}
+	return a
+}
+
+var geometryNames = map[vectortile.Tile_GeomType]string{
+	vectortile.Tile_POINT:      "Point",
+	vectortile.Tile_LINESTRING: "LineString",
+	vectortile.Tile_POLYGON:    "Polygon",
+}
+
+func (l *layerStats) tileStats(name string) TileStatsLayer {
+	out := TileStatsLayer{
+		Layer:      name,
+		Count:      l.count,
+		Attributes: make([]TileStatsAttribute, 0, len(l.attributes)),
+	}
+	most := 0
+	for geomType, n := range l.geometries {
+		if geometryNames[geomType] != "" && (n > most || (n == most && geometryNames[geomType] < out.Geometry)) {
+			out.Geometry = geometryNames[geomType]
+			most = n
+		}
+	}
+	for key, a := range l.attributes {
+		attr := TileStatsAttribute{
+			Attribute: key,
+			Count:     a.distinct.Count(),
+			Type:      a.typ,
+			Values:    append([]interface{}{}, a.values...),
+		}
+		if a.numbers {
+			min, max := a.min, a.max
+			attr.Min = &min
+			attr.Max = &max
+		}
+		out.Attributes = append(out.Attributes, attr)
+	}
+	sort.Slice(out.Attributes, func(i, j int) bool { return out.Attributes[i].Attribute < out.Attributes[j].Attribute })
+	out.AttributeCount = len(out.Attributes)
+	return out
+}
+
+// MergeTileStats combines the tilestats of several exports, eg: the shards of a sharded export.
+// Feature counts are added up. Distinct counts can't be combined exactly, so the largest is kept.
+func MergeTileStats(stats []*TileStats, sampleSize int) *TileStats {
+	var merged *TileStats
+	layerIndex := map[string]int{}
+	for _, s := range stats {
+		if s == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &TileStats{Layers: []TileStatsLayer{}}
+		}
+		for _, layer := range s.Layers {
+			i, ok := layerIndex[layer.Layer]
+			if !ok {
+				layerIndex[layer.Layer] = len(merged.Layers)
+				// copy the attributes so merging doesn't change the inputs
+				layer.Attributes = append([]TileStatsAttribute{}, layer.Attributes...)
+				merged.Layers = append(merged.Layers, layer)
+				continue
+			}
+			mergeTileStatsLayer(&merged.Layers[i], layer, sampleSize)
+		}
+	}
+	if merged != nil {
+		sort.Slice(merged.Layers, func(i, j int) bool { return merged.Layers[i].Layer < merged.Layers[j].Layer })
+		merged.LayerCount = len(merged.Layers)
+	}
+	return merged
+}
+
+func mergeTileStatsLayer(layer *TileStatsLayer, other TileStatsLayer, sampleSize int) {
+	if other.Count > layer.Count {
+		layer.Geometry = other.Geometry
+	}
+	layer.Count += other.Count
+	attrIndex := map[string]int{}
+	for i, a := range layer.Attributes {
+		attrIndex[a.Attribute] = i
+	}
+	for _, a := range other.Attributes {
+		i, ok := attrIndex[a.Attribute]
+		if !ok {
+			attrIndex[a.Attribute] = len(layer.Attributes)
+			layer.Attributes = append(layer.Attributes, a)
+			continue
+		}
+		attr := &layer.Attributes[i]
+		if a.Count > attr.Count {
+			attr.Count = a.Count
+		}
+		if attr.Type != a.Type {
+			attr.Type = "mixed"
+		}
+		if a.Min != nil && (attr.Min == nil || *a.Min < *attr.Min) {
+			attr.Min = a.Min
+		}
+		if a.Max != nil && (attr.Max == nil || *a.Max > *attr.Max) {
+			attr.Max = a.Max
+		}
+		attr.Values = append([]interface{}{}, attr.Values...)
+		seen := map[interface{}]bool{}
+		for _, v := range attr.Values {
+			seen[v] = true
+		}
+		for _, v := range a.Values {
+			if len(attr.Values) >= sampleSize {
+				break
+			}
+			if !seen[v] {
+				seen[v] = true
+				attr.Values = append(attr.Values, v)
+			}
+		}
+	}
+	sort.Slice(layer.Attributes, func(i, j int) bool { return layer.Attributes[i].Attribute < layer.Attributes[j].Attribute })
+	layer.AttributeCount = len(layer.Attributes)
+}
+
+// attributeStatsJSON is an attributeStats as saved with the export journal
+type attributeStatsJSON struct {
+	Type     string        `json:"type"`
+	Distinct []uint64      `json:"distinct"`
+	Values   []interface{} `json:"values"`
+	Min      float64       `json:"min"`
+	Max      float64       `json:"max"`
+	Numbers  bool          `json:"numbers"`
+}
+
+// layerStatsJSON is a layerStats as saved with the export journal
+type layerStatsJSON struct {
+	Count      int                              `json:"count"`
+	Geometries map[vectortile.Tile_GeomType]int `json:"geometries"`
+	Attributes map[string]attributeStatsJSON    `json:"attributes"`
+}
+
+func (l *layerStats) toJSON() layerStatsJSON {
+	out := layerStatsJSON{Count: l.count, Geometries: l.geometries, Attributes: make(map[string]attributeStatsJSON, len(l.attributes))}
+	for key, a := range l.attributes {
+		out.Attributes[key] = attributeStatsJSON{Type: a.typ, Distinct: a.distinct.hashes, Values: a.values, Min: a.min, Max: a.max, Numbers: a.numbers}
+	}
+	return out
+}
+
+func (in layerStatsJSON) layerStats() *layerStats {
+	l := newLayerStats()
+	l.count = in.Count
+	for geomType, n := range in.Geometries {
+		l.geometries[geomType] = n
+	}
+	for key, a := range in.Attributes {
+		// json numbers are read back as float64, like the numbers in the values
+		attr := &attributeStats{typ: a.Type, distinct: distinctSketch{hashes: a.Distinct}, values: a.Values, sampled: map[string]bool{}, min: a.Min, max: a.Max, numbers: a.Numbers}
+		for _, v := range attr.values {
+			attr.sampled[valueKey(v)] = true
+		}
+		l.attributes[key] = attr
+	}
+	return l
+}
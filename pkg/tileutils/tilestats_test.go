@@ -0,0 +1,98 @@
+package tileutils
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"os"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistinctSketch(t *testing.T) {
+	s := distinctSketch{}
+	for i := 0; i < 100; i++ {
+		s.Add(uint64(i))
+		s.Add(uint64(i))
+	}
+	assert.Equal(t, 100, s.Count())
+
+	s = distinctSketch{}
+	var buf [8]byte
+	for i := 0; i < 100000; i++ {
+		binary.LittleEndian.PutUint64(buf[:], uint64(i))
+		h := fnv.New64a()
+		h.Write(buf[:])
+		s.Add(h.Sum64())
+	}
+	assert.Len(t, s.hashes, sketchSize)
+	assert.InEpsilon(t, 100000, s.Count(), 0.2)
+}
+
+func TestTileStats(t *testing.T) {
+	fc := geojson.NewFeatureCollection()
+	for i := 0; i < 5; i++ {
+		f := geojson.NewFeature(orb.Point{float64(i), float64(i)})
+		f.Properties["rank"] = i
+		f.Properties["name"] = "place"
+		fc.Append(f)
+	}
+	line := geojson.NewFeature(orb.LineString{{0, 0}, {1, 1}})
+	line.Properties["name"] = 7
+	fc.Append(line)
+	tile, err := mvt.Marshal(mvt.NewLayers(map[string]*geojson.FeatureCollection{"places": fc}))
+	require.Nil(t, err)
+
+	c := NewLayerCollector(3)
+	require.Nil(t, c.Add(tile))
+	stats := c.TileStats()
+	require.Equal(t, 1, stats.LayerCount)
+	layer := stats.Layers[0]
+	assert.Equal(t, "places", layer.Layer)
+	assert.Equal(t, 6, layer.Count)
+	assert.Equal(t, "Point", layer.Geometry)
+	require.Equal(t, 2, layer.AttributeCount)
+
+	name := layer.Attributes[0]
+	assert.Equal(t, "name", name.Attribute)
+	assert.Equal(t, "mixed", name.Type)
+	assert.Equal(t, 2, name.Count)
+
+	rank := layer.Attributes[1]
+	assert.Equal(t, "rank", rank.Attribute)
+	assert.Equal(t, "number", rank.Type)
+	assert.Equal(t, 5, rank.Count)
+	assert.Len(t, rank.Values, 3)
+	assert.Equal(t, 0.0, *rank.Min)
+	assert.Equal(t, 4.0, *rank.Max)
+
+	// shards merge by adding up features and widening ranges
+	other := &TileStats{LayerCount: 1, Layers: []TileStatsLayer{{
+		Layer: "places", Count: 10, Geometry: "Point",
+		Attributes: []TileStatsAttribute{{Attribute: "rank", Count: 2, Type: "number", Values: []interface{}{9.0}, Min: rank.Max, Max: rank.Max}},
+	}}}
+	merged := MergeTileStats([]*TileStats{stats, nil, other}, 4)
+	assert.Equal(t, 16, merged.Layers[0].Count)
+	assert.Equal(t, 5, merged.Layers[0].Attributes[1].Count)
+	assert.Len(t, merged.Layers[0].Attributes[1].Values, 4)
+	assert.Nil(t, MergeTileStats(nil, 4))
+}
+
+func TestCreateMetadataTileStats(t *testing.T) {
+	data, err := os.ReadFile("testdata/5-7-12.mvt")
+	require.Nil(t, err)
+	c := NewLayerCollector(DefaultTileStatsValues)
+	require.Nil(t, c.Add(data))
+	tj := &TileJSON{MinZoom: -1, MaxZoom: -1, VectorLayers: []VectorLayer{{ID: "ne_10m_roads"}}}
+	meta := CreateMetadata(tj, CreateMetadataOptions{Format: MbTilesFormatPbf, Fields: c.Fields(), TileStats: c.TileStats()})
+
+	var parsed map[string]json.RawMessage
+	require.Nil(t, json.Unmarshal([]byte(meta["json"]), &parsed))
+	assert.Contains(t, parsed, "vector_layers")
+	assert.Contains(t, parsed, "tilestats")
+}
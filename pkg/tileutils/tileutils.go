@@ -3,12 +3,20 @@ package tileutils
 
 import (
 	"fmt"
+	"log/slog"
 	"math"
 	"os"
 	"strconv"
 	"strings"
 )
 
+const (
+	// maxTileZoom is the deepest zoom accepted in tile lists
+	maxTileZoom = 30
+	// maxLineTiles is the most tiles a single range line of a tile list may expand to
+	maxLineTiles = 1 << 20
+)
+
 func lonToX(lon float64, zoom int) int {
 	n := math.Pow(2, float64(zoom))
 	return int(math.Floor((lon + 180) / 360 * n))
@@ -20,6 +28,18 @@ func latToY(lat float64, zoom int) int {
 	return int(math.Floor((1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * n))
 }
 
+// xToLon returns the longitude of the left edge of tile column x
+func xToLon(x int, zoom int) float64 {
+	n := math.Pow(2, float64(zoom))
+	return float64(x)/n*360 - 180
+}
+
+// yToLat returns the latitude of the top edge of tile row y
+func yToLat(y int, zoom int) float64 {
+	n := math.Pow(2, float64(zoom))
+	return math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/n))) * 180 / math.Pi
+}
+
 type TileCoords struct {
 	Z int
 	X int
@@ -34,36 +54,71 @@ type BoundingBox struct {
 	Bottom float64
 }
 
+// Ancestor returns the tile at zoom z which contains this tile.
+// If z is not lower than the tile's zoom, the tile itself is returned.
+func (tc TileCoords) Ancestor(z int) TileCoords {
+	if z >= tc.Z {
+		return tc
+	}
+	shift := tc.Z - z
+	return TileCoords{
+		Z: z,
+		X: tc.X >> shift,
+		Y: tc.Y >> shift,
+	}
+}
+
 // ListTiles returns a list of all the tiles within the given zooms based on the TileJSON
 func ListTiles(zooms []int, tj *TileJSON) []TileCoords {
 	tiles := make([]TileCoords, 0, 2<<zooms[len(zooms)-1])
 	for _, z := range zooms {
-		newTiles := tilesInBbox(BoundingBox{
-			Left:   tj.Bounds[0],
-			Right:  tj.Bounds[2],
-			Bottom: tj.Bounds[1],
-			Top:    tj.Bounds[3],
-		}, z)
-		tiles = append(tiles, newTiles...)
+		boxes := tj.BoundingBoxes(z)
+		if len(boxes) == 1 {
+			tiles = append(tiles, tilesInBbox(boxes[0], z)...)
+			continue
+		}
+		// overlapping zoom bounds would list the same tiles more than once
+		seen := TileSet{}
+		for _, bbox := range boxes {
+			for _, tc := range tilesInBbox(bbox, z) {
+				if seen.Contains(tc) {
+					continue
+				}
+				seen.Add(tc)
+				tiles = append(tiles, tc)
+			}
+		}
 	}
 	return tiles
 }
 
-// tilesInBbox returns a list of all tiles within that lat/lon bounding box at the specified zoom level
-func tilesInBbox(bbox BoundingBox, zoom int) []TileCoords {
-	fmt.Printf("zoom: %d\n", zoom)
-	xMin := lonToX(bbox.Left, zoom)
-	xMax := lonToX(bbox.Right, zoom)
-	yMin := latToY(bbox.Top, zoom)
-	yMax := latToY(bbox.Bottom, zoom)
+// tileRange returns the inclusive range of tile columns and rows covering the bounding box at the given zoom
+func tileRange(bbox BoundingBox, zoom int) (xMin, xMax, yMin, yMax int) {
+	xMin = lonToX(bbox.Left, zoom)
+	xMax = lonToX(bbox.Right, zoom)
+	yMin = latToY(bbox.Top, zoom)
+	yMax = latToY(bbox.Bottom, zoom)
 	tileMax := (1 << zoom) - 1
 
+	if xMin < 0 {
+		xMin = 0
+	}
+	if yMin < 0 {
+		yMin = 0
+	}
 	if xMax > tileMax {
 		xMax = tileMax
 	}
 	if yMax > tileMax {
 		yMax = tileMax
 	}
+	return
+}
+
+// tilesInBbox returns a list of all tiles within that lat/lon bounding box at the specified zoom level
+func tilesInBbox(bbox BoundingBox, zoom int) []TileCoords {
+	slog.Debug("listing tiles", "zoom", zoom)
+	xMin, xMax, yMin, yMax := tileRange(bbox, zoom)
 
 	tiles := make([]TileCoords, 0, (xMax-xMin)*(yMax-yMin))
 
@@ -98,17 +153,8 @@ func tilesInBbox(bbox BoundingBox, zoom int) []TileCoords {
 	return tiles
 }
 
-// RoundRobinTiles assigns tiles to workers in round robin fashion
-func RoundRobinTiles(input []TileCoords, numWorkers int) [][]TileCoords {
-	out := make([][]TileCoords, numWorkers)
-	for i, v := range input {
-		index := i % numWorkers
-		out[index] = append(out[index], v)
-	}
-	return out
-}
-
-// TilesFromFile reads the tile coordinates to generate from a file
+// TilesFromFile reads the tile coordinates to generate from a file.
+// Each line may be a z/x/y tile coordinate, a z/x1-x2/y1-y2 range of tiles, or a quadkey.
 func TilesFromFile(filename string) ([]TileCoords, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -117,31 +163,165 @@ func TilesFromFile(filename string) ([]TileCoords, error) {
 	lines := strings.Split(string(data), "\n")
 	tiles := make([]TileCoords, 0, len(lines))
 	for _, l := range lines {
+		l = strings.TrimSpace(l)
 		if l == "" {
 			continue
 		}
-		coords := strings.Split(l, "/")
-		if len(coords) != 3 {
-			return nil, fmt.Errorf("invalid line, expected 3 coordinates but got %d: %s", len(coords), l)
-		}
-		z, err := strconv.Atoi(coords[0])
+		lineTiles, err := ParseTileLine(l)
 		if err != nil {
 			return nil, err
 		}
-		x, err := strconv.Atoi(coords[1])
+		tiles = append(tiles, lineTiles...)
+	}
+	return tiles, nil
+}
+
+// ParseTileLine parses a single line of a tile list: a z/x/y tile coordinate,
+// a z/x1-x2/y1-y2 range (either part may also be a single value), or a quadkey
+func ParseTileLine(l string) ([]TileCoords, error) {
+	if !strings.Contains(l, "/") {
+		tc, err := parseQuadkey(l)
 		if err != nil {
 			return nil, err
 		}
-		y, err := strconv.Atoi(coords[2])
+		return []TileCoords{tc}, nil
+	}
+	coords := strings.Split(l, "/")
+	if len(coords) != 3 {
+		return nil, fmt.Errorf("invalid line, expected 3 coordinates but got %d: %s", len(coords), l)
+	}
+	z, err := strconv.Atoi(coords[0])
+	if err != nil {
+		return nil, err
+	}
+	if z < 0 || z > maxTileZoom {
+		return nil, fmt.Errorf("invalid zoom (%d): %s", z, l)
+	}
+	xMin, xMax, err := parseTileSpan(coords[1], z)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate: %s: %w", l, err)
+	}
+	yMin, yMax, err := parseTileSpan(coords[2], z)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y coordinate: %s: %w", l, err)
+	}
+	if count := (xMax - xMin + 1) * (yMax - yMin + 1); count > maxLineTiles {
+		return nil, fmt.Errorf("range of %d tiles is larger than the limit of %d: %s", count, maxLineTiles, l)
+	}
+	tiles := make([]TileCoords, 0, (xMax-xMin+1)*(yMax-yMin+1))
+	for x := xMin; x <= xMax; x++ {
+		for y := yMin; y <= yMax; y++ {
+			tiles = append(tiles, TileCoords{
+				Z: z,
+				X: x,
+				Y: y,
+			})
+		}
+	}
+	return tiles, nil
+}
+
+// ParseTilePath parses the z/x/y.mvt path of a tile URL, with or without a leading slash
+func ParseTilePath(p string) (TileCoords, error) {
+	l, ok := strings.CutSuffix(strings.TrimPrefix(p, "/"), ".mvt")
+	if !ok || strings.Count(l, "/") != 2 || strings.Contains(l, "-") {
+		return TileCoords{}, fmt.Errorf("invalid tile path, expected z/x/y.mvt: %s", p)
+	}
+	tiles, err := ParseTileLine(l)
+	if err != nil {
+		return TileCoords{}, err
+	}
+	return tiles[0], nil
+}
+
+// parseTileSpan parses a single tile column/row or an inclusive min-max range, checking it is valid at zoom z
+func parseTileSpan(s string, z int) (int, int, error) {
+	start, end, isRange := strings.Cut(s, "-")
+	min, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	max := min
+	if isRange {
+		max, err = strconv.Atoi(end)
 		if err != nil {
-			return nil, err
+			return 0, 0, err
+		}
+	}
+	if min < 0 || max < min || max >= 1<<z {
+		return 0, 0, fmt.Errorf("%s is out of range for zoom %d", s, z)
+	}
+	return min, max, nil
+}
+
+// parseQuadkey converts a quadkey string (eg: "0231") to tile coordinates
+func parseQuadkey(key string) (TileCoords, error) {
+	if len(key) > maxTileZoom {
+		return TileCoords{}, fmt.Errorf("invalid quadkey, longer than zoom %d: %s", maxTileZoom, key)
+	}
+	tc := TileCoords{Z: len(key)}
+	for _, c := range key {
+		if c < '0' || c > '3' {
+			return TileCoords{}, fmt.Errorf("invalid line, expected z/x/y or a quadkey: %s", key)
+		}
+		digit := int(c - '0')
+		tc.X = tc.X<<1 | digit&1
+		tc.Y = tc.Y<<1 | digit>>1
+	}
+	return tc, nil
+}
+
+// ExpandTiles adds the ancestors of every tile at each of the given zooms below the tile's zoom,
+// as used for expire lists where a changed tile invalidates all the tiles above it.
+// If descendants is true, the children at each of the zooms above the tile's zoom are also added.
+// Only tiles at the given zooms are returned, and the result contains no duplicates.
+func ExpandTiles(tiles []TileCoords, zooms []int, descendants bool) []TileCoords {
+	seen := TileSet{}
+	out := make([]TileCoords, 0, len(tiles))
+	add := func(tc TileCoords) {
+		if seen.Contains(tc) {
+			return
+		}
+		seen.Add(tc)
+		out = append(out, tc)
+	}
+	for _, tc := range tiles {
+		for _, z := range zooms {
+			if z == tc.Z {
+				add(tc)
+			} else if z < tc.Z {
+				add(tc.Ancestor(z))
+			} else if z > tc.Z && descendants {
+				shift := z - tc.Z
+				for x := tc.X << shift; x < (tc.X+1)<<shift; x++ {
+					for y := tc.Y << shift; y < (tc.Y+1)<<shift; y++ {
+						add(TileCoords{Z: z, X: x, Y: y})
+					}
+				}
+			}
 		}
-		tc := TileCoords{
-			Z: z,
-			X: x,
-			Y: y,
+	}
+	return out
+}
+
+// AppendUnique appends the extra tiles which are not already in tiles, skipping duplicates within extra
+func AppendUnique(tiles []TileCoords, extra []TileCoords) []TileCoords {
+	extraSet := TileSet{}
+	for _, tc := range extra {
+		extraSet.Add(tc)
+	}
+	present := TileSet{}
+	for _, tc := range tiles {
+		if extraSet.Contains(tc) {
+			present.Add(tc)
 		}
+	}
+	for _, tc := range extra {
+		if present.Contains(tc) {
+			continue
+		}
+		present.Add(tc)
 		tiles = append(tiles, tc)
 	}
-	return tiles, nil
+	return tiles
 }
@@ -3,6 +3,7 @@ package tileutils
 import (
 	"fmt"
 	"math"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -42,3 +43,77 @@ func TestTilesInBbox(t *testing.T) {
 	}
 
 }
+
+func TestParseTileLine(t *testing.T) {
+	tiles, err := ParseTileLine("14/2816/6547")
+	assert.Nil(t, err)
+	assert.Equal(t, []TileCoords{{Z: 14, X: 2816, Y: 6547}}, tiles)
+
+	tiles, err = ParseTileLine("3/1-2/5-6")
+	assert.Nil(t, err)
+	assert.Equal(t, []TileCoords{
+		{Z: 3, X: 1, Y: 5},
+		{Z: 3, X: 1, Y: 6},
+		{Z: 3, X: 2, Y: 5},
+		{Z: 3, X: 2, Y: 6},
+	}, tiles)
+
+	tiles, err = ParseTileLine("3/4/0-1")
+	assert.Nil(t, err)
+	assert.Len(t, tiles, 2)
+
+	// quadkey 213 is x=3, y=5 at zoom 3
+	tiles, err = ParseTileLine("213")
+	assert.Nil(t, err)
+	assert.Equal(t, []TileCoords{{Z: 3, X: 3, Y: 5}}, tiles)
+
+	for _, line := range []string{"3/8/0", "3/2-1/0", "2/1", "a/1/1", "214", "14/0-2047/0-1023", strings.Repeat("0", 31)} {
+		_, err = ParseTileLine(line)
+		assert.NotNil(t, err, line)
+	}
+}
+
+func TestParseTilePath(t *testing.T) {
+	tc, err := ParseTilePath("/14/2816/6547.mvt")
+	assert.Nil(t, err)
+	assert.Equal(t, TileCoords{Z: 14, X: 2816, Y: 6547}, tc)
+
+	for _, p := range []string{"/3/8/0.mvt", "/3/1-2/0.mvt", "/3/1/0.png", "/3/1.mvt", "/213.mvt", "/1/0/0/0.mvt"} {
+		_, err = ParseTilePath(p)
+		assert.NotNil(t, err, p)
+	}
+}
+
+func TestExpandTiles(t *testing.T) {
+	tiles := []TileCoords{
+		{Z: 3, X: 4, Y: 2},
+		{Z: 3, X: 5, Y: 2},
+	}
+	expanded := ExpandTiles(tiles, []int{1, 2, 3}, false)
+	assert.Equal(t, []TileCoords{
+		{Z: 1, X: 1, Y: 0},
+		{Z: 2, X: 2, Y: 1},
+		{Z: 3, X: 4, Y: 2},
+		{Z: 3, X: 5, Y: 2},
+	}, expanded)
+
+	// the expired tile itself isn't at an export zoom
+	expanded = ExpandTiles(tiles[:1], []int{2, 4}, true)
+	assert.Equal(t, []TileCoords{
+		{Z: 2, X: 2, Y: 1},
+		{Z: 4, X: 8, Y: 4},
+		{Z: 4, X: 8, Y: 5},
+		{Z: 4, X: 9, Y: 4},
+		{Z: 4, X: 9, Y: 5},
+	}, expanded)
+}
+
+func TestAppendUnique(t *testing.T) {
+	tiles := []TileCoords{{Z: 1, X: 0, Y: 0}, {Z: 1, X: 1, Y: 0}}
+	extra := []TileCoords{{Z: 1, X: 1, Y: 0}, {Z: 2, X: 0, Y: 0}, {Z: 2, X: 0, Y: 0}}
+	assert.Equal(t, []TileCoords{
+		{Z: 1, X: 0, Y: 0},
+		{Z: 1, X: 1, Y: 0},
+		{Z: 2, X: 0, Y: 0},
+	}, AppendUnique(tiles, extra))
+}
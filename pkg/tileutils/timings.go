@@ -0,0 +1,147 @@
+package tileutils
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// timingsBatchSize is the number of timings buffered before they are written in one transaction
+const timingsBatchSize = 1000
+
+type tileTiming struct {
+	tile     TileCoords
+	duration time.Duration
+}
+
+// TimingStore persists the render time of each tile in a sqlite file, so that later runs can
+// start with the slowest tiles. Timings are buffered and written in batches.
+// It is safe for concurrent use.
+type TimingStore struct {
+	db      *sql.DB
+	mu      sync.Mutex
+	pending []tileTiming
+}
+
+// OpenTimingStore opens (or creates) a sqlite file of tile timings
+func OpenTimingStore(filename string) (*TimingStore, error) {
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS tile_timings (
+			z INT NOT NULL,
+			x INT NOT NULL,
+			y INT NOT NULL,
+			millis INT NOT NULL,
+			PRIMARY KEY (z, x, y)
+		);
+	`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating timings table in %s: %w", filename, err)
+	}
+	return &TimingStore{
+		db:      db,
+		pending: make([]tileTiming, 0, timingsBatchSize),
+	}, nil
+}
+
+// Record stores the render time of a tile, replacing the time from any previous run
+func (s *TimingStore) Record(tc TileCoords, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, tileTiming{tile: tc, duration: d})
+	if len(s.pending) < timingsBatchSize {
+		return nil
+	}
+	return s.flush()
+}
+
+// Flush writes out any buffered timings
+func (s *TimingStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+func (s *TimingStore) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO tile_timings (z, x, y, millis) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, t := range s.pending {
+		if _, err := stmt.Exec(t.tile.Z, t.tile.X, t.tile.Y, t.duration.Milliseconds()); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// keep the timings for the next flush until they are committed
+	s.pending = s.pending[:0]
+	return nil
+}
+
+// Load returns the recorded render times of the tiles which took at least min
+func (s *TimingStore) Load(min time.Duration) (map[TileCoords]time.Duration, error) {
+	rows, err := s.db.Query("SELECT z, x, y, millis FROM tile_timings WHERE millis >= ?", min.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	costs := map[TileCoords]time.Duration{}
+	for rows.Next() {
+		var tc TileCoords
+		var millis int64
+		if err := rows.Scan(&tc.Z, &tc.X, &tc.Y, &millis); err != nil {
+			return nil, err
+		}
+		costs[tc] = time.Duration(millis) * time.Millisecond
+	}
+	return costs, rows.Err()
+}
+
+// Close writes out any buffered timings and closes the file
+func (s *TimingStore) Close() error {
+	err := s.Flush()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// SlowestFirst moves the tiles with a known cost to the front, the most expensive first, following a
+// longest-processing-time-first policy so the slowest tiles don't end up running alone at the end.
+// The remaining tiles keep their order, and so their spatial locality. It also returns the number of
+// tiles moved to the front, which a TileQueue should hand out one at a time.
+func SlowestFirst(tiles []TileCoords, costs map[TileCoords]time.Duration) ([]TileCoords, int) {
+	if len(costs) == 0 {
+		return tiles, 0
+	}
+	slow := []TileCoords{}
+	rest := make([]TileCoords, 0, len(tiles))
+	for _, tc := range tiles {
+		if _, ok := costs[tc]; ok {
+			slow = append(slow, tc)
+			continue
+		}
+		rest = append(rest, tc)
+	}
+	sort.SliceStable(slow, func(i, j int) bool {
+		return costs[slow[i]] > costs[slow[j]]
+	})
+	return append(slow, rest...), len(slow)
+}
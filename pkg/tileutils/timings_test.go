@@ -0,0 +1,61 @@
+package tileutils
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimingStore(t *testing.T) {
+	filename := path.Join(t.TempDir(), "timings.sqlite")
+	store, err := OpenTimingStore(filename)
+	require.Nil(t, err)
+	for i := 0; i < timingsBatchSize+5; i++ {
+		require.Nil(t, store.Record(TileCoords{Z: 14, X: i, Y: 1}, time.Duration(i)*time.Millisecond))
+	}
+	// a later run replaces the timing
+	require.Nil(t, store.Record(TileCoords{Z: 14, X: 3, Y: 1}, 5*time.Second))
+	require.Nil(t, store.Close())
+
+	store, err = OpenTimingStore(filename)
+	require.Nil(t, err)
+	defer store.Close()
+	costs, err := store.Load(time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, map[TileCoords]time.Duration{
+		{Z: 14, X: 3, Y: 1}:    5 * time.Second,
+		{Z: 14, X: 1000, Y: 1}: time.Second,
+		{Z: 14, X: 1001, Y: 1}: 1001 * time.Millisecond,
+		{Z: 14, X: 1002, Y: 1}: 1002 * time.Millisecond,
+		{Z: 14, X: 1003, Y: 1}: 1003 * time.Millisecond,
+		{Z: 14, X: 1004, Y: 1}: 1004 * time.Millisecond,
+	}, costs)
+}
+
+func TestSlowestFirst(t *testing.T) {
+	tiles := []TileCoords{
+		{Z: 1, X: 0, Y: 0},
+		{Z: 1, X: 0, Y: 1},
+		{Z: 1, X: 1, Y: 0},
+		{Z: 1, X: 1, Y: 1},
+	}
+	costs := map[TileCoords]time.Duration{
+		{Z: 1, X: 1, Y: 0}: time.Second,
+		{Z: 1, X: 1, Y: 1}: time.Minute,
+		{Z: 5, X: 1, Y: 1}: time.Hour, // not part of this export
+	}
+	sorted, slow := SlowestFirst(tiles, costs)
+	assert.Equal(t, []TileCoords{
+		{Z: 1, X: 1, Y: 1},
+		{Z: 1, X: 1, Y: 0},
+		{Z: 1, X: 0, Y: 0},
+		{Z: 1, X: 0, Y: 1},
+	}, sorted)
+	assert.Equal(t, 2, slow)
+	sorted, slow = SlowestFirst(tiles, nil)
+	assert.Equal(t, tiles, sorted)
+	assert.Equal(t, 0, slow)
+}
@@ -3,6 +3,7 @@ package tileutils
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 
 	"github.com/paulmach/orb/encoding/mvt"
 )
@@ -10,13 +11,13 @@ import (
 func printTile(data []byte) {
 	layers, err := mvt.Unmarshal(data)
 	if err != nil {
-		fmt.Printf("[error] %v", err)
+		slog.Error("error decoding tile", "error", err)
 		return
 	}
 	for _, l := range layers {
 		jsonBytes, err := json.MarshalIndent(l, "", "    ")
 		if err != nil {
-			fmt.Printf("[error] layer=%s, %v", l.Name, err)
+			slog.Error("error encoding layer", "layer", l.Name, "error", err)
 			continue
 		}
 		fmt.Println((string(jsonBytes)))
@@ -1,19 +1,16 @@
 package tileutils
 
 import (
+	"database/sql"
 	"fmt"
+	"log/slog"
 	"os"
 	"path"
 	"strconv"
-	"time"
 
 	"github.com/twpayne/go-mbtiles"
 )
 
-const (
-	mbTilesInsertRetries = 5
-)
-
 // TileWriter abstracts how to write out tiles, allowing for different formats and implementations
 type TileWriter interface {
 	// New creates a new TileWriter for this type
@@ -39,7 +36,7 @@ func (fw *FileWriter) Write(z, x, y int, tileData []byte) error {
 	basePath := path.Join(fw.Path, strconv.Itoa(z), strconv.Itoa(x))
 	err := os.MkdirAll(basePath, 0755)
 	if err != nil {
-		fmt.Printf("error making directory for output (%s): %v\n", basePath, err)
+		slog.Error("error making directory for output", "path", basePath, "error", err)
 		return err
 	}
 	filename := path.Join(basePath, fmt.Sprintf("%d.mvt", y))
@@ -55,7 +52,7 @@ func (fw *FileWriter) New() (TileWriter, func(), error) {
 type DummyWriter struct{}
 
 func (fw *DummyWriter) Write(z, x, y int, tileData []byte) error {
-	fmt.Printf("%d/%d/%d - %d bytes\n", z, x, y, len(tileData))
+	slog.Info("tile", TileAttr(TileCoords{Z: z, X: x, Y: y}), "bytes", len(tileData))
 	return nil
 }
 
@@ -68,35 +65,37 @@ func (fw *DummyWriter) New() (TileWriter, func(), error) {
 // Parameters:
 //   - Filename: the output file to be written
 //   - Writer: an instance of mbtiles.Writer to be used when writing the tiles
+//   - DB: the sqlite database underneath the Writer, for queries mbtiles.Writer doesn't support
+//   - Journal: record written tiles in a journal table, committed with the tiles, so the export can be resumed
+//   - Resume: open the existing Filename instead of truncating it
 type MbTilesWriter struct {
 	Filename string
 	Writer   *mbtiles.Writer
+	DB       *sql.DB
+	Journal  bool
+	Resume   bool
 }
 
-func (w *MbTilesWriter) Write(z, x, y int, tileData []byte) error {
-	var err error
-	for i := 0; i < mbTilesInsertRetries; i++ {
-		err = w.Writer.InsertTile(z, x, y, tileData)
-		if err == nil {
-			return nil
-		}
-		fmt.Printf("err during database write, waiting to retry (%d): %v\n", i, err)
-		time.Sleep(time.Duration(100) * time.Millisecond)
+// insert writes the tiles, along with their journal entries if the journal is on
+func (w *MbTilesWriter) insert(data []mbtiles.TileData) error {
+	if w.Journal {
+		return w.insertJournaled(data)
 	}
-	return err
+	if len(data) == 1 {
+		return w.Writer.InsertTile(data[0].Z, data[0].X, data[0].Y, data[0].Data)
+	}
+	return w.Writer.BulkInsertTile(data)
+}
+
+// Write commits a single tile. Writes aren't retried, so the output should only be written from one goroutine,
+// eg: through a BatchWriter, to avoid waiting on sqlite's write lock.
+func (w *MbTilesWriter) Write(z, x, y int, tileData []byte) error {
+	return w.insert([]mbtiles.TileData{{Z: z, X: x, Y: y, Data: tileData}})
 }
 
+// BulkWrite commits the tiles in a single transaction
 func (w *MbTilesWriter) BulkWrite(data []mbtiles.TileData) error {
-	var err error
-	for i := 0; i < mbTilesInsertRetries; i++ {
-		err = w.Writer.BulkInsertTile(data)
-		if err == nil {
-			return nil
-		}
-		fmt.Printf("err during database write, waiting to retry (%d): %v\n", i, err)
-		time.Sleep(time.Duration(100) * time.Millisecond)
-	}
-	return err
+	return w.insert(data)
 }
 
 func (w *MbTilesWriter) WriteMetadata(name, value string) error {
@@ -117,11 +116,19 @@ func (w *MbTilesWriter) New() (TileWriter, func(), error) {
 	if err := os.MkdirAll(path.Dir(w.Filename), 0755); err != nil {
 		return nil, nil, err
 	}
-	if _, err := os.Create(w.Filename); err != nil {
+	if w.Resume {
+		if _, err := os.Stat(w.Filename); err != nil {
+			return nil, nil, fmt.Errorf("error opening output to resume: %w", err)
+		}
+	} else if _, err := os.Create(w.Filename); err != nil {
 		return nil, nil, err
 	}
 	// create a mbtiles writer, which is a wrapper around sqlite3
-	_writer, err := mbtiles.NewWriter(w.Filename)
+	db, err := sql.Open("sqlite3", w.Filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening database: %w", err)
+	}
+	_writer, err := mbtiles.NewWriterWithDB(db)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error creating writer: %w", err)
 	}
@@ -137,17 +144,29 @@ func (w *MbTilesWriter) New() (TileWriter, func(), error) {
 	if err := _writer.DeleteTileIndex(); err != nil {
 		return nil, nil, fmt.Errorf("error deleting tile index: %w", err)
 	}
-	// set optimizations
-	if err := _writer.SetOptimizations(mbtiles.Optimizations{
+	if w.Journal {
+		if _, err := db.Exec(createJournalSQL); err != nil {
+			return nil, nil, fmt.Errorf("error creating export journal: %w", err)
+		}
+		// an in-memory sqlite journal can corrupt the file on a crash, which would lose the export journal too
+		if _, err := db.Exec("PRAGMA journal_mode = WAL"); err != nil {
+			return nil, nil, fmt.Errorf("error setting journal mode: %w", err)
+		}
+	} else if err := _writer.SetOptimizations(mbtiles.Optimizations{
 		JournalModeMemory: true,
 	}); err != nil {
 		return nil, nil, fmt.Errorf("error setting optimizations: %w", err)
 	}
 
 	w.Writer = _writer
+	w.DB = db
 	return w,
 		func() {
 			w.Writer.CreateTileIndex()
+			if w.Journal {
+				// fold the write-ahead log back in, so the output is a single file again
+				w.DB.Exec("PRAGMA journal_mode = DELETE")
+			}
 			w.Writer.Close()
 		},
 		nil